@@ -0,0 +1,162 @@
+package jqfunc
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// adHocCacheSize is the capacity of the compile cache of an ad-hoc function
+// not given one with WithCompileCache
+const adHocCacheSize = 128
+
+// adHocFunctions numbers the ad-hoc functions, so that a compile cache they
+// share keeps the compiled functions of each apart
+var adHocFunctions atomic.Uint64
+
+// AdHocFunction returns an HCL function that runs a query given as its first
+// argument, for one-off transforms that do not merit a block:
+//
+//	jq(".items[] | select(.kind == $kind)", data, { kind = "a" })
+//
+// The second argument is the input, treated exactly as the input of a
+// block-defined function. The optional third argument is an object or map
+// whose keys become $variables. The options apply as they do to New.
+//
+// Compiled queries are kept in a compile cache: the one given with
+// WithCompileCache, or else a private cache of the 128 most recently used
+// queries.
+func AdHocFunction(opts ...Option) function.Function {
+	cfg := newConfig(opts)
+
+	// The cache holds whole compiled functions rather than only their code,
+	// so that a function keeps the compiled queries it pools for feeding
+	// inputs from one call to the next. The options of a function are fixed,
+	// so its number and the query identify the compiled function.
+	cache := cfg.compileCache
+	if cache == nil {
+		cache = NewCompileCache(adHocCacheSize)
+	}
+	id := adHocFunctions.Add(1)
+
+	returnType := cty.DynamicPseudoType
+	if cfg.returnType != cty.NilType {
+		returnType = cfg.returnType
+	}
+
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{
+				Name:        "query",
+				Type:        cty.String,
+				AllowMarked: true,
+			},
+			{
+				Name:             "input",
+				Type:             cty.DynamicPseudoType,
+				AllowNull:        true,
+				AllowDynamicType: true,
+				AllowMarked:      true,
+			},
+		},
+		VarParam: &function.Parameter{
+			Name:             "variables",
+			Type:             cty.DynamicPseudoType,
+			AllowNull:        true,
+			AllowDynamicType: true,
+			AllowMarked:      true,
+		},
+		Type: function.StaticReturnType(returnType),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			if len(args) > 3 {
+				return cty.NilVal, function.NewArgErrorf(3, "expected at most one object of variables")
+			}
+
+			// Marks on the query and the variables object itself apply to
+			// the result like marks on the other arguments
+			query, marks := args[0].UnmarkDeep()
+
+			var params []string
+			var values []cty.Value
+			if len(args) == 3 {
+				var err error
+				var varMarks cty.ValueMarks
+				if params, values, varMarks, err = adHocVariables(args[2]); err != nil {
+					return cty.NilVal, function.NewArgError(2, err)
+				}
+				for mark := range varMarks {
+					marks[mark] = struct{}{}
+				}
+			}
+
+			key := functionCacheKey(id, query.AsString(), params)
+			var jqFunc *JqFunction
+			if cached, ok := cache.get(key); ok {
+				jqFunc = cached.(*JqFunction)
+			} else {
+				funcDef := cfg.functionDef("jq", query.AsString(), params, cfg.defRange)
+				funcDef.CompileCache = nil // the whole function is cached instead
+				var diags hcl.Diagnostics
+				if jqFunc, diags = compileJqFunction(funcDef); diags.HasErrors() {
+					return cty.NilVal, function.NewArgErrorf(0, "%s", diags[0].Detail)
+				}
+				cache.put(key, jqFunc)
+			}
+
+			result, err := executeJqFunction(jqFunc, append([]cty.Value{args[1]}, values...))
+			if err != nil {
+				return cty.NilVal, err
+			}
+			return result.WithMarks(marks), nil
+		},
+	})
+}
+
+// adHocVariables returns the variable names of an object or map, sorted,
+// with their values and the marks on the object itself
+func adHocVariables(vars cty.Value) ([]string, []cty.Value, cty.ValueMarks, error) {
+	vars, marks := vars.Unmark()
+	ty := vars.Type()
+	if !ty.IsObjectType() && !ty.IsMapType() {
+		return nil, nil, nil, fmt.Errorf("variables must be an object, got %s", ty.FriendlyName())
+	}
+	if vars.IsNull() {
+		return nil, nil, marks, nil
+	}
+
+	attrs := vars.AsValueMap()
+	params := make([]string, 0, len(attrs))
+	for name := range attrs {
+		if !validJqVariable(name) {
+			return nil, nil, nil, fmt.Errorf("variable name %q is not a valid identifier", name)
+		}
+		params = append(params, name)
+	}
+	sort.Strings(params)
+
+	values := make([]cty.Value, len(params))
+	for i, name := range params {
+		values[i] = attrs[name]
+	}
+	return params, values, marks, nil
+}
+
+// validJqVariable reports whether name can follow $ in a jq query
+func validJqVariable(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
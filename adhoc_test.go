@@ -0,0 +1,168 @@
+package jqfunc
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/itchyny/gojq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+func evalAdHoc(t *testing.T, jq function.Function, expr string, vars map[string]cty.Value) (cty.Value, hcl.Diagnostics) {
+	t.Helper()
+	parsed, diags := hclsyntax.ParseExpression([]byte(expr), "expr.hcl", hcl.InitialPos)
+	require.False(t, diags.HasErrors(), "Expression parsing should succeed: %s", diags)
+	return parsed.Value(&hcl.EvalContext{
+		Variables: vars,
+		Functions: map[string]function.Function{"jq": jq},
+	})
+}
+
+func TestAdHocFunction(t *testing.T) {
+	jq := AdHocFunction()
+	data := cty.ObjectVal(map[string]cty.Value{
+		"items": cty.TupleVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("a"), "kind": cty.StringVal("x")}),
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("b"), "kind": cty.StringVal("y")}),
+		}),
+	})
+	vars := map[string]cty.Value{
+		"data": data,
+		"doc":  cty.StringVal(`{"n": 2}`),
+		"amap": cty.MapVal(map[string]cty.Value{"a": cty.StringVal("x")}),
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected cty.Value
+	}{
+		{"cty input", `jq("[.items[].name]", data)`, cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")})},
+		{"json string input", `jq(".n * 10", doc)`, cty.StringVal("20")},
+		{"variables", `jq(".items[] | select(.kind == $kind) | .name", data, { kind = "y" })`, cty.StringVal("b")},
+		{"several variables", `jq("$a + $b", null, { b = 2, a = 1 })`, cty.NumberIntVal(3)},
+		{"map of variables", `jq("$a", null, amap)`, cty.StringVal("x")},
+		{"no results", `jq("empty", data)`, cty.NullVal(cty.DynamicPseudoType)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, diags := evalAdHoc(t, jq, tt.expr, vars)
+			require.False(t, diags.HasErrors(), "Evaluation should succeed: %s", diags)
+			assert.True(t, result.RawEquals(tt.expected), "Expected %#v, got %#v", tt.expected, result)
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		errorTests := []struct {
+			name   string
+			expr   string
+			detail string
+		}{
+			{"invalid query", `jq(".[", null)`, "Failed to parse jq query"},
+			{"undefined variable", `jq("$x", null)`, "variable not defined: $x"},
+			{"variables not an object", `jq(".", null, [1])`, "variables must be an object"},
+			{"invalid variable name", `jq(".", null, { "a-b" = 1 })`, "not a valid identifier"},
+			{"too many arguments", `jq(".", null, {}, {})`, "expected at most one object of variables"},
+			{"null query", `jq(null, null)`, "argument must not be null"},
+			{"execution error", `jq("error(\"boom\")", null)`, "boom"},
+		}
+		for _, tt := range errorTests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, diags := evalAdHoc(t, jq, tt.expr, vars)
+				require.True(t, diags.HasErrors(), "Evaluation should fail")
+				assert.Contains(t, diags[0].Detail, tt.detail)
+			})
+		}
+	})
+
+	t.Run("unknown query", func(t *testing.T) {
+		result, err := jq.Call([]cty.Value{cty.UnknownVal(cty.String), data})
+		require.NoError(t, err)
+		assert.False(t, result.IsKnown())
+	})
+
+	t.Run("null input", func(t *testing.T) {
+		for _, input := range []cty.Value{cty.NullVal(cty.String), cty.NullVal(cty.DynamicPseudoType)} {
+			result, err := jq.Call([]cty.Value{cty.StringVal(`. == null`), input})
+			require.NoError(t, err, "input %#v", input)
+			assert.True(t, result.RawEquals(cty.True), "Got %#v for input %#v", result, input)
+		}
+
+		result, err := jq.Call([]cty.Value{cty.StringVal("."), cty.NullVal(cty.String)})
+		require.NoError(t, err)
+		assert.True(t, result.IsNull(), "Got %#v", result)
+	})
+
+	t.Run("marks", func(t *testing.T) {
+		result, err := jq.Call([]cty.Value{
+			cty.StringVal("$a").Mark("query"),
+			cty.NullVal(cty.DynamicPseudoType),
+			cty.ObjectVal(map[string]cty.Value{"a": cty.StringVal("x").Mark("value")}).Mark("object"),
+		})
+		require.NoError(t, err)
+		assert.True(t, result.RawEquals(cty.StringVal("x").WithMarks(cty.NewValueMarks("query", "value", "object"))), "Got %#v", result)
+	})
+
+	t.Run("options", func(t *testing.T) {
+		typed := AdHocFunction(WithReturnType(cty.List(cty.String)), WithSensitive(true), WithTimeout(20*time.Millisecond))
+
+		result, err := typed.Call([]cty.Value{cty.StringVal("[]"), cty.NullVal(cty.DynamicPseudoType)})
+		require.NoError(t, err)
+		assert.True(t, result.RawEquals(cty.ListValEmpty(cty.String).Mark(SensitiveMark)), "Got %#v", result)
+
+		_, err = typed.Call([]cty.Value{cty.StringVal("[last(repeat(1))]"), cty.NullVal(cty.DynamicPseudoType)})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "timed out after 20ms")
+	})
+
+	t.Run("compiled queries are cached", func(t *testing.T) {
+		cache := NewCompileCache(2)
+		cached := AdHocFunction(WithCompileCache(cache))
+
+		for i := 0; i < 3; i++ {
+			_, err := cached.Call([]cty.Value{cty.StringVal("$a"), cty.NullVal(cty.DynamicPseudoType), cty.ObjectVal(map[string]cty.Value{"a": cty.NumberIntVal(int64(i))})})
+			require.NoError(t, err)
+			_, err = cached.Call([]cty.Value{cty.StringVal(".x"), cty.StringVal(`{"x": 1}`)})
+			require.NoError(t, err)
+		}
+		assert.Equal(t, CacheStats{Hits: 4, Misses: 2, Entries: 2}, cache.Stats())
+
+		// Different variables compile separately
+		_, err := cached.Call([]cty.Value{cty.StringVal("$a"), cty.NullVal(cty.DynamicPseudoType), cty.ObjectVal(map[string]cty.Value{"a": cty.True, "b": cty.True})})
+		require.NoError(t, err)
+		assert.Equal(t, uint64(3), cache.Stats().Misses)
+	})
+	t.Run("ndjson queries stay compiled between calls", func(t *testing.T) {
+		// Count compilations with a compiler option that does nothing else
+		var compiles atomic.Int64
+		noop := reflect.ValueOf(gojq.WithModuleLoader(nil))
+		counting := reflect.MakeFunc(noop.Type(), func(args []reflect.Value) []reflect.Value {
+			compiles.Add(1)
+			return noop.Call(args)
+		}).Interface().(gojq.CompilerOption)
+
+		cache := NewCompileCache(0)
+		lines := AdHocFunction(WithInputFormat(FormatNDJSON), WithCompilerOptions(counting), WithCompileCache(cache))
+
+		const calls = 100
+		for i := 0; i < calls; i++ {
+			result, err := lines.Call([]cty.Value{cty.StringVal("[., input]"), cty.StringVal("1\n2\n")})
+			require.NoError(t, err)
+			require.Equal(t, "[1,2]", result.AsString())
+		}
+		assert.Equal(t, CacheStats{Hits: calls - 1, Misses: 1, Entries: 1}, cache.Stats())
+
+		// One compilation for the function and one for the query fed its
+		// inputs, which is pooled; the race detector makes pools drop some
+		// of what they are given, so allow for recompiling now and then
+		assert.Less(t, compiles.Load(), int64(calls/2), "queries should not be compiled for every call")
+	})
+}
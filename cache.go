@@ -0,0 +1,145 @@
+package jqfunc
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// CompileCache is a least-recently-used cache of compiled jq queries, keyed
+// by the query text, the parameter names and a key for the compiler options.
+// It lets hosts that decode the same configuration repeatedly skip parsing
+// and compiling queries they have already seen. It is safe for concurrent
+// use, and a single cache may be shared by any number of decoders.
+//
+// Compiler options are Go functions and cannot be compared, so functions
+// compiled with options are only cached when the options are identified by
+// WithCompilerOptionsKey; otherwise they bypass the cache.
+type CompileCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // front is most recently used
+	entries  map[cacheKey]*list.Element
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// CacheStats reports the activity of a CompileCache
+type CacheStats struct {
+	Hits    uint64 // lookups that found a compiled query
+	Misses  uint64 // lookups that compiled the query
+	Entries int    // compiled queries currently held
+}
+
+type cacheKey [sha256.Size]byte
+
+// cacheEntry holds a compiled query, or a whole compiled function for the
+// ad-hoc function
+type cacheEntry struct {
+	key   cacheKey
+	value interface{}
+}
+
+// NewCompileCache creates a cache holding at most capacity compiled queries.
+// A capacity of zero or less means no limit.
+func NewCompileCache(capacity int) *CompileCache {
+	return &CompileCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[cacheKey]*list.Element),
+	}
+}
+
+// Stats returns the hit and miss counters and the number of entries
+func (c *CompileCache) Stats() CacheStats {
+	c.mu.Lock()
+	entries := c.order.Len()
+	c.mu.Unlock()
+
+	return CacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: entries,
+	}
+}
+
+// Purge removes all entries. The counters are kept.
+func (c *CompileCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[cacheKey]*list.Element)
+}
+
+// get returns the entry for key, marking it as recently used
+func (c *CompileCache) get(key cacheKey) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).value, true
+}
+
+// put stores a compiled query or function, evicting the least recently used
+// entries over capacity
+func (c *CompileCache) put(key cacheKey, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		// Compiled concurrently by another caller; either result will do
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value})
+
+	for c.capacity > 0 && c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Cache keys start with a kind, so that a compiled query and a compiled
+// ad-hoc function can never share a key
+const (
+	queryKeyKind    = "query"
+	functionKeyKind = "function"
+)
+
+// compileCacheKey hashes everything that determines the compiled code
+func compileCacheKey(query string, params []string, optionsKey string, inputs bool) cacheKey {
+	return hashCacheKey(append([]string{queryKeyKind, query, optionsKey, strconv.FormatBool(inputs)}, params...))
+}
+
+// functionCacheKey hashes the ad-hoc function a compiled function belongs
+// to, which determines all of its options, with its query and parameters
+func functionCacheKey(function uint64, query string, params []string) cacheKey {
+	return hashCacheKey(append([]string{functionKeyKind, strconv.FormatUint(function, 10), query}, params...))
+}
+
+// hashCacheKey hashes the parts of a key. Each part is length-prefixed so
+// that different splits cannot collide.
+func hashCacheKey(parts []string) cacheKey {
+	h := sha256.New()
+	for _, part := range parts {
+		var n [8]byte
+		binary.BigEndian.PutUint64(n[:], uint64(len(part)))
+		h.Write(n[:])
+		h.Write([]byte(part))
+	}
+
+	var key cacheKey
+	h.Sum(key[:0])
+	return key
+}
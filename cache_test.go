@@ -0,0 +1,163 @@
+package jqfunc
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/itchyny/gojq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const cacheTestConfig = `
+jq "names" {
+    params = []
+    query = "[.[].name]"
+}
+
+jq "pick" {
+    params = [field]
+    query = "[.[][$field]]"
+}
+`
+
+func TestCompileCache(t *testing.T) {
+	t.Run("repeated decoding hits", func(t *testing.T) {
+		cache := NewCompileCache(16)
+		body := parseTestBody(t, cacheTestConfig)
+
+		for i := 0; i < 3; i++ {
+			functions, _, diags := DecodeJqFunctionsWithOptions(body, WithCompileCache(cache))
+			require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+
+			result, err := functions["pick"].Call([]cty.Value{cty.StringVal(`[{"id": 1}]`), cty.StringVal("id")})
+			require.NoError(t, err, "Function call should succeed")
+			assert.Equal(t, "[1]", result.AsString())
+		}
+
+		assert.Equal(t, CacheStats{Hits: 4, Misses: 2, Entries: 2}, cache.Stats())
+	})
+
+	t.Run("parameters are part of the key", func(t *testing.T) {
+		cache := NewCompileCache(16)
+		_, err := New("a", "$x", []string{"x"}, WithCompileCache(cache))
+		require.NoError(t, err)
+		_, err = New("b", "$x", []string{"x", "y"}, WithCompileCache(cache))
+		require.NoError(t, err)
+		_, err = New("c", "$x", []string{"x"}, WithCompileCache(cache))
+		require.NoError(t, err)
+
+		assert.Equal(t, CacheStats{Hits: 1, Misses: 2, Entries: 2}, cache.Stats())
+	})
+
+	t.Run("errors are not cached", func(t *testing.T) {
+		cache := NewCompileCache(16)
+		for i := 0; i < 2; i++ {
+			_, err := New("bad", ".[", nil, WithCompileCache(cache))
+			require.Error(t, err)
+		}
+		assert.Equal(t, CacheStats{Hits: 0, Misses: 2, Entries: 0}, cache.Stats())
+	})
+
+	t.Run("compiler options need a key", func(t *testing.T) {
+		cache := NewCompileCache(16)
+		shout := gojq.WithFunction("shout", 0, 0, func(v interface{}, _ []interface{}) interface{} {
+			return v.(string) + "!"
+		})
+
+		_, err := New("f", "shout", nil, WithCompileCache(cache), WithCompilerOptions(shout))
+		require.NoError(t, err)
+		assert.Equal(t, CacheStats{}, cache.Stats(), "Options without a key should bypass the cache")
+
+		for i := 0; i < 2; i++ {
+			_, err = New("f", "shout", nil, WithCompileCache(cache), WithCompilerOptions(shout), WithCompilerOptionsKey("shout"))
+			require.NoError(t, err)
+		}
+		assert.Equal(t, CacheStats{Hits: 1, Misses: 1, Entries: 1}, cache.Stats())
+
+		// Without the options the query does not compile, so it must not hit
+		_, err = New("f", "shout", nil, WithCompileCache(cache))
+		require.Error(t, err)
+	})
+
+	t.Run("least recently used entry is evicted", func(t *testing.T) {
+		cache := NewCompileCache(2)
+		compile := func(query string) {
+			_, err := New("f", query, nil, WithCompileCache(cache))
+			require.NoError(t, err)
+		}
+
+		compile(".a")
+		compile(".b")
+		compile(".a") // hit; .b is now least recently used
+		compile(".c") // evicts .b
+		compile(".a") // hit
+		compile(".b") // miss
+
+		assert.Equal(t, CacheStats{Hits: 2, Misses: 4, Entries: 2}, cache.Stats())
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		cache := NewCompileCache(0)
+		_, err := New("f", ".", nil, WithCompileCache(cache))
+		require.NoError(t, err)
+		cache.Purge()
+		assert.Equal(t, CacheStats{Misses: 1}, cache.Stats())
+	})
+
+	t.Run("concurrent use", func(t *testing.T) {
+		cache := NewCompileCache(8)
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					_, err := New("f", fmt.Sprintf(".[%d]", (i+j)%12), nil, WithCompileCache(cache))
+					assert.NoError(t, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		stats := cache.Stats()
+		assert.Equal(t, uint64(400), stats.Hits+stats.Misses)
+		assert.LessOrEqual(t, stats.Entries, 8)
+	})
+}
+
+func BenchmarkDecode(b *testing.B) {
+	var config string
+	for i := 0; i < 20; i++ {
+		config += fmt.Sprintf(`
+jq "f%d" {
+    params = [a, b]
+    query = "[.items[] | select(.kind == $a) | {name, total: (.price * .qty + $b)}] | sort_by(.total) | reverse | .[0:%d]"
+}
+`, i, i+1)
+	}
+	body := parseTestBody(b, config)
+
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _, diags := DecodeJqFunctionsWithOptions(body)
+			if diags.HasErrors() {
+				b.Fatal(diags)
+			}
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		cache := NewCompileCache(64)
+		for i := 0; i < b.N; i++ {
+			_, _, diags := DecodeJqFunctionsWithOptions(body, WithCompileCache(cache))
+			if diags.HasErrors() {
+				b.Fatal(diags)
+			}
+		}
+		stats := cache.Stats()
+		b.ReportMetric(float64(stats.Hits)/float64(stats.Hits+stats.Misses), "hit-ratio")
+	})
+}
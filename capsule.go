@@ -0,0 +1,270 @@
+package jqfunc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// CapsuleConverter converts the values of one cty capsule type to and from
+// values jq can process. Functions may run concurrently, so ToJq and FromJq
+// must be safe to call from multiple goroutines.
+type CapsuleConverter struct {
+	// Name optionally makes the capsule type available in return_type
+	// expressions under this identifier, e.g. return_type = list(ipprefix)
+	Name string
+
+	// ToJq converts an encapsulated value (as returned by
+	// cty.Value.EncapsulatedValue) to a JSON-compatible Go value: nil, bool,
+	// a Go number, string, []interface{} or map[string]interface{}.
+	ToJq func(encapsulated interface{}) (interface{}, error)
+
+	// FromJq optionally converts a jq result back to an encapsulated value.
+	// It is used where the expected result type is the capsule type, either
+	// because it was declared as the return type or because the input had
+	// the capsule type at that position. If it returns an error, the result
+	// is converted as a plain value instead.
+	FromJq func(v interface{}) (interface{}, error)
+}
+
+// ConverterRegistry maps capsule types to their converters. It is safe for
+// concurrent use.
+type ConverterRegistry struct {
+	mu         sync.RWMutex
+	converters map[cty.Type]CapsuleConverter
+}
+
+// DefaultConverters is the registry used by functions that were not given
+// one explicitly
+var DefaultConverters = NewConverterRegistry()
+
+// NewConverterRegistry creates an empty converter registry
+func NewConverterRegistry() *ConverterRegistry {
+	return &ConverterRegistry{converters: make(map[cty.Type]CapsuleConverter)}
+}
+
+// Register sets the converter for a capsule type, replacing any previous
+// one. It panics if ty is not a capsule type or conv has no ToJq function.
+func (r *ConverterRegistry) Register(ty cty.Type, conv CapsuleConverter) {
+	if !ty.IsCapsuleType() {
+		panic(fmt.Sprintf("jqfunc: cannot register converter for non-capsule type %s", ty.FriendlyName()))
+	}
+	if conv.ToJq == nil {
+		panic(fmt.Sprintf("jqfunc: converter for %s has no ToJq function", ty.FriendlyName()))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.converters[ty] = conv
+}
+
+// LookupName returns the capsule type registered under a return_type name
+func (r *ConverterRegistry) LookupName(name string) (cty.Type, bool) {
+	if r == nil || name == "" {
+		return cty.NilType, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for ty, conv := range r.converters {
+		if conv.Name == name {
+			return ty, true
+		}
+	}
+	return cty.NilType, false
+}
+
+// Lookup returns the converter for a capsule type
+func (r *ConverterRegistry) Lookup(ty cty.Type) (CapsuleConverter, bool) {
+	if r == nil {
+		return CapsuleConverter{}, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	conv, ok := r.converters[ty]
+	return conv, ok
+}
+
+// typeConstraint decodes a return_type expression. It accepts everything
+// typeexpr.TypeConstraint does, plus the names of registered capsule types
+// wherever a type may appear: on their own, as the element type of list, set
+// and map, as an attribute type of object and as an element type of tuple.
+func (r *ConverterRegistry) typeConstraint(expr hcl.Expression) (cty.Type, hcl.Diagnostics) {
+	switch e := expr.(type) {
+	case *hclsyntax.ScopeTraversalExpr:
+		if len(e.Traversal) == 1 {
+			if ty, ok := r.LookupName(e.Traversal.RootName()); ok {
+				return ty, nil
+			}
+		}
+	case *hclsyntax.FunctionCallExpr:
+		collections := map[string]func(cty.Type) cty.Type{
+			"list": cty.List,
+			"set":  cty.Set,
+			"map":  cty.Map,
+		}
+		if makeType, ok := collections[e.Name]; ok && len(e.Args) == 1 {
+			elemType, diags := r.typeConstraint(e.Args[0])
+			if diags.HasErrors() {
+				return cty.DynamicPseudoType, diags
+			}
+			return makeType(elemType), diags
+		}
+
+		// Structural types without capsule names are left to typeexpr,
+		// which also checks them in more detail
+		if len(e.Args) == 1 && r.mentionsCapsule(e.Args[0]) {
+			switch e.Name {
+			case "object":
+				return r.objectConstraint(e)
+			case "tuple":
+				return r.tupleConstraint(e)
+			}
+		}
+	}
+	return typeexpr.TypeConstraint(expr)
+}
+
+// objectConstraint decodes an object type expression whose attribute types
+// name capsule types
+func (r *ConverterRegistry) objectConstraint(call *hclsyntax.FunctionCallExpr) (cty.Type, hcl.Diagnostics) {
+	attrDefs, diags := hcl.ExprMap(call.Args[0])
+	if diags.HasErrors() {
+		return cty.DynamicPseudoType, hcl.Diagnostics{invalidTypeDiag(call.Args[0], call,
+			"Object type constructor requires a map whose keys are attribute names and whose values are the corresponding attribute types.")}
+	}
+
+	attrTypes := make(map[string]cty.Type, len(attrDefs))
+	var optional []string
+	for _, attrDef := range attrDefs {
+		name := hcl.ExprAsKeyword(attrDef.Key)
+		if name == "" {
+			diags = diags.Append(invalidTypeDiag(attrDef.Key, call, "Object constructor map keys must be attribute names."))
+			continue
+		}
+		if _, exists := attrTypes[name]; exists {
+			diags = diags.Append(invalidTypeDiag(attrDef.Key, call, "Object constructor map keys must be unique."))
+			continue
+		}
+
+		typeExpr := attrDef.Value
+		if modifier, ok := typeExpr.(*hclsyntax.FunctionCallExpr); ok && modifier.Name == "optional" {
+			if len(modifier.Args) != 1 {
+				diags = diags.Append(invalidTypeDiag(modifier, call, "Optional attribute modifier expects only one argument: the attribute type."))
+				continue
+			}
+			optional = append(optional, name)
+			typeExpr = modifier.Args[0]
+		}
+
+		attrType, attrDiags := r.typeConstraint(typeExpr)
+		diags = append(diags, attrDiags...)
+		attrTypes[name] = attrType
+	}
+	if diags.HasErrors() {
+		return cty.DynamicPseudoType, diags
+	}
+	return cty.ObjectWithOptionalAttrs(attrTypes, optional), diags
+}
+
+// tupleConstraint decodes a tuple type expression whose element types name
+// capsule types
+func (r *ConverterRegistry) tupleConstraint(call *hclsyntax.FunctionCallExpr) (cty.Type, hcl.Diagnostics) {
+	elemDefs, diags := hcl.ExprList(call.Args[0])
+	if diags.HasErrors() {
+		return cty.DynamicPseudoType, hcl.Diagnostics{invalidTypeDiag(call.Args[0], call,
+			"Tuple type constructor requires a list of element types.")}
+	}
+
+	elemTypes := make([]cty.Type, len(elemDefs))
+	for i, elemDef := range elemDefs {
+		elemType, elemDiags := r.typeConstraint(elemDef)
+		diags = append(diags, elemDiags...)
+		elemTypes[i] = elemType
+	}
+	if diags.HasErrors() {
+		return cty.DynamicPseudoType, diags
+	}
+	return cty.Tuple(elemTypes), diags
+}
+
+// mentionsCapsule reports whether a type expression names a registered
+// capsule type anywhere within it
+func (r *ConverterRegistry) mentionsCapsule(expr hcl.Expression) bool {
+	node, ok := expr.(hclsyntax.Node)
+	if !ok {
+		return false
+	}
+	found := false
+	hclsyntax.VisitAll(node, func(n hclsyntax.Node) hcl.Diagnostics {
+		if traversal, ok := n.(*hclsyntax.ScopeTraversalExpr); ok && len(traversal.Traversal) == 1 {
+			if _, named := r.LookupName(traversal.Traversal.RootName()); named {
+				found = true
+			}
+		}
+		return nil
+	})
+	return found
+}
+
+// invalidTypeDiag reports an invalid part of a return_type expression the
+// way typeexpr does
+func invalidTypeDiag(subject, context hcl.Expression, detail string) *hcl.Diagnostic {
+	return &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  "Invalid type specification",
+		Detail:   detail,
+		Subject:  subject.Range().Ptr(),
+		Context:  context.Range().Ptr(),
+	}
+}
+
+// typeString writes a type as a return_type expression, like
+// typeexpr.TypeString, naming capsule types by their registered names
+func (r *ConverterRegistry) typeString(ty cty.Type) string {
+	switch {
+	case ty.IsCapsuleType():
+		r.mu.RLock()
+		name := r.converters[ty].Name
+		r.mu.RUnlock()
+		if name == "" {
+			return ty.FriendlyName()
+		}
+		return name
+	case ty.IsListType():
+		return fmt.Sprintf("list(%s)", r.typeString(ty.ElementType()))
+	case ty.IsSetType():
+		return fmt.Sprintf("set(%s)", r.typeString(ty.ElementType()))
+	case ty.IsMapType():
+		return fmt.Sprintf("map(%s)", r.typeString(ty.ElementType()))
+	case ty.IsObjectType():
+		attrs := ty.AttributeTypes()
+		names := make([]string, 0, len(attrs))
+		for name := range attrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i, name := range names {
+			attrType := r.typeString(attrs[name])
+			if !hclsyntax.ValidIdentifier(name) {
+				name = fmt.Sprintf("%q", name)
+			}
+			names[i] = name + "=" + attrType
+		}
+		return "object({" + strings.Join(names, ",") + "})"
+	case ty.IsTupleType():
+		elems := make([]string, len(ty.TupleElementTypes()))
+		for i, elemType := range ty.TupleElementTypes() {
+			elems[i] = r.typeString(elemType)
+		}
+		return "tuple([" + strings.Join(elems, ",") + "])"
+	}
+	return typeexpr.TypeString(ty)
+}
@@ -0,0 +1,221 @@
+package jqfunc
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+var testPrefixType = cty.Capsule("ip prefix", reflect.TypeOf(netip.Prefix{}))
+
+func init() {
+	DefaultConverters.Register(testPrefixType, CapsuleConverter{
+		Name: "test_ipprefix",
+		ToJq: func(encapsulated interface{}) (interface{}, error) {
+			return encapsulated.(*netip.Prefix).String(), nil
+		},
+		FromJq: func(v interface{}) (interface{}, error) {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string, got %T", v)
+			}
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, err
+			}
+			return &prefix, nil
+		},
+	})
+}
+
+func prefixVal(s string) cty.Value {
+	prefix := netip.MustParsePrefix(s)
+	return cty.CapsuleVal(testPrefixType, &prefix)
+}
+
+func TestCapsuleConverters(t *testing.T) {
+	hclCode := `
+jqfunction "identity" {
+    params = []
+    query = "."
+}
+
+jqfunction "private" {
+    params = []
+    query = "map(select(startswith(\"10.\")))"
+}
+
+jqfunction "bits" {
+    params = []
+    query = "split(\"/\")[1] | tonumber"
+}
+
+jqfunction "cidr" {
+    params = []
+    query = ".cidr"
+    return_type = test_ipprefix
+}
+
+jqfunction "cidrs" {
+    params = []
+    query = "[.[].cidr]"
+    return_type = set(test_ipprefix)
+}
+
+jqfunction "network" {
+    params = []
+    query = "{cidr: .cidr, name: .name}"
+    return_type = object({cidr = test_ipprefix, name = string, gateway = optional(test_ipprefix)})
+}
+
+jqfunction "route" {
+    params = []
+    query = "[.cidr, .metric]"
+    return_type = tuple([test_ipprefix, number])
+}
+`
+	parser := hclparse.NewParser()
+	file, diags := parser.ParseHCL([]byte(hclCode), "capsule.hcl")
+	require.False(t, diags.HasErrors(), "HCL parsing should succeed: %s", diags)
+
+	functions, _, diags := DecodeJqFunctions(file.Body, "jqfunction")
+	require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+
+	t.Run("capsule converted for jq", func(t *testing.T) {
+		result, err := functions["bits"].Call([]cty.Value{prefixVal("10.0.0.0/8")})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.RawEquals(cty.NumberIntVal(8)), "Got %#v", result)
+	})
+
+	t.Run("capsule restored from input type", func(t *testing.T) {
+		input := cty.ListVal([]cty.Value{
+			prefixVal("10.0.0.0/8"),
+			prefixVal("192.168.0.0/16"),
+			prefixVal("10.1.0.0/16"),
+		})
+
+		result, err := functions["private"].Call([]cty.Value{input})
+		require.NoError(t, err, "Function call should succeed")
+		require.Equal(t, cty.List(testPrefixType), result.Type())
+
+		elems := result.AsValueSlice()
+		require.Len(t, elems, 2)
+		assert.Equal(t, "10.0.0.0/8", elems[0].EncapsulatedValue().(*netip.Prefix).String())
+		assert.Equal(t, "10.1.0.0/16", elems[1].EncapsulatedValue().(*netip.Prefix).String())
+	})
+
+	t.Run("capsule from declared return type", func(t *testing.T) {
+		result, err := functions["cidr"].Call([]cty.Value{cty.StringVal(`{"cidr": "172.16.0.0/12"}`)})
+		require.NoError(t, err, "Function call should succeed")
+		require.Equal(t, testPrefixType, result.Type())
+		assert.Equal(t, "172.16.0.0/12", result.EncapsulatedValue().(*netip.Prefix).String())
+	})
+
+	t.Run("set of capsules from declared return type", func(t *testing.T) {
+		input := `[{"cidr": "10.0.0.0/8"}, {"cidr": "172.16.0.0/12"}]`
+
+		result, err := functions["cidrs"].Call([]cty.Value{cty.StringVal(input)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, cty.Set(testPrefixType), result.Type())
+		assert.Equal(t, 2, result.LengthInt())
+	})
+
+	t.Run("invalid value for declared capsule type", func(t *testing.T) {
+		_, err := functions["cidr"].Call([]cty.Value{cty.StringVal(`{"cidr": "not a prefix"}`)})
+		require.Error(t, err, "Should fail to convert to the declared type")
+		assert.Contains(t, err.Error(), "declared return type test_ipprefix")
+	})
+
+	t.Run("capsules in declared object type", func(t *testing.T) {
+		result, err := functions["network"].Call([]cty.Value{cty.StringVal(`{"cidr": "10.0.0.0/8", "name": "lan"}`)})
+		require.NoError(t, err, "Function call should succeed")
+		require.Equal(t, cty.Object(map[string]cty.Type{
+			"cidr":    testPrefixType,
+			"name":    cty.String,
+			"gateway": testPrefixType,
+		}), result.Type())
+		assert.Equal(t, "10.0.0.0/8", result.GetAttr("cidr").EncapsulatedValue().(*netip.Prefix).String())
+		assert.True(t, result.GetAttr("gateway").IsNull())
+
+		_, err = functions["network"].Call([]cty.Value{cty.StringVal(`{"cidr": "not a prefix", "name": "lan"}`)})
+		require.Error(t, err, "Should fail to convert to the declared type")
+		assert.Contains(t, err.Error(), "declared return type object({cidr=test_ipprefix,gateway=test_ipprefix,name=string})")
+	})
+
+	t.Run("capsules in declared tuple type", func(t *testing.T) {
+		result, err := functions["route"].Call([]cty.Value{cty.StringVal(`{"cidr": "192.168.0.0/16", "metric": 10}`)})
+		require.NoError(t, err, "Function call should succeed")
+		require.Equal(t, cty.Tuple([]cty.Type{testPrefixType, cty.Number}), result.Type())
+		assert.Equal(t, "192.168.0.0/16", result.Index(cty.NumberIntVal(0)).EncapsulatedValue().(*netip.Prefix).String())
+		assert.True(t, result.Index(cty.NumberIntVal(1)).RawEquals(cty.NumberIntVal(10)))
+	})
+
+	t.Run("invalid object type with capsules", func(t *testing.T) {
+		hclCode := `
+jqfunction "bad" {
+    query = "."
+    return_type = object({cidr = test_ipprefix, cidr = string})
+}
+`
+		file, diags := hclparse.NewParser().ParseHCL([]byte(hclCode), "capsule.hcl")
+		require.False(t, diags.HasErrors(), "HCL parsing should succeed: %s", diags)
+
+		_, _, diags = DecodeJqFunctions(file.Body, "jqfunction")
+		require.True(t, diags.HasErrors(), "Duplicate attributes should be rejected")
+		assert.Equal(t, "Object constructor map keys must be unique.", diags[0].Detail)
+	})
+
+	t.Run("unregistered capsule type", func(t *testing.T) {
+		other := cty.Capsule("other", reflect.TypeOf(""))
+		value := "x"
+
+		_, err := functions["identity"].Call([]cty.Value{cty.CapsuleVal(other, &value)})
+		require.Error(t, err, "Should fail without a converter")
+		assert.Contains(t, err.Error(), "no converter registered for capsule type other")
+	})
+}
+
+func TestConverterRegistry(t *testing.T) {
+	t.Run("register rejects non-capsule types", func(t *testing.T) {
+		registry := NewConverterRegistry()
+		assert.Panics(t, func() {
+			registry.Register(cty.String, CapsuleConverter{ToJq: func(interface{}) (interface{}, error) { return nil, nil }})
+		})
+	})
+
+	t.Run("converter output is normalized", func(t *testing.T) {
+		ty := cty.Capsule("counter", reflect.TypeOf(int64(0)))
+		registry := NewConverterRegistry()
+		registry.Register(ty, CapsuleConverter{
+			ToJq: func(encapsulated interface{}) (interface{}, error) {
+				return map[string]interface{}{"count": *encapsulated.(*int64)}, nil
+			},
+		})
+
+		count := int64(3)
+		c := &converter{capsules: registry}
+		result, err := c.ctyToJq(cty.CapsuleVal(ty, &count))
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"count": 3}, result)
+	})
+
+	t.Run("unsupported converter output", func(t *testing.T) {
+		ty := cty.Capsule("broken", reflect.TypeOf(0))
+		registry := NewConverterRegistry()
+		registry.Register(ty, CapsuleConverter{
+			ToJq: func(interface{}) (interface{}, error) { return struct{}{}, nil },
+		})
+
+		value := 0
+		c := &converter{capsules: registry}
+		_, err := c.ctyToJq(cty.CapsuleVal(ty, &value))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unsupported value of type struct {}")
+	})
+}
@@ -0,0 +1,368 @@
+// Command hcljq runs a jq function defined in an HCL file, for trying out
+// and debugging functions without writing a Go program:
+//
+//	hcljq run config.hcl get_name --arg rate=0.08 < input.json
+//
+// Standard input is the input of the function and the result is printed on
+// standard output. Errors, including HCL diagnostics, are printed on
+// standard error, and the exit status is 1, or 2 for usage errors.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+
+	jqfunc "github.com/tsarna/hcl-jqfunc"
+)
+
+const usage = `Usage: hcljq run [flags] FILE FUNCTION
+
+Calls FUNCTION, a jq function defined in the HCL file FILE, with standard
+input as its input and prints the result.
+
+Flags:
+  --arg NAME=EXPR       pass the HCL expression EXPR as parameter NAME
+  --argjson NAME=JSON   pass the JSON value JSON as parameter NAME
+  --input string|value  pass standard input as a string, which the function
+                        parses in its input format, or parse it as JSON and
+                        pass the value (default string)
+  --output json|hcl     print the result as JSON or as an HCL expression; a
+                        string is printed as is in JSON mode (default json)
+  --block-type TYPE     the block type of jq functions (default jq)
+`
+
+// Exit statuses
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command with the given arguments and streams, returning the
+// exit status
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "run" {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	opts, err := parseRunFlags(args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Fprint(stdout, usage)
+			return exitOK
+		}
+		fmt.Fprintf(stderr, "hcljq: %v\n\n%s", err, usage)
+		return exitUsage
+	}
+
+	input, err := io.ReadAll(stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "hcljq: reading input: %v\n", err)
+		return exitFailure
+	}
+
+	files := make(map[string]*hcl.File)
+	result, diags := call(opts, input, files)
+	if diags.HasErrors() {
+		writer := hcl.NewDiagnosticTextWriter(stderr, files, 78, false)
+		_ = writer.WriteDiagnostics(diags)
+		return exitFailure
+	}
+
+	output, err := formatResult(result, opts.output)
+	if err != nil {
+		fmt.Fprintf(stderr, "hcljq: %v\n", err)
+		return exitFailure
+	}
+	_, _ = stdout.Write(output)
+	return exitOK
+}
+
+// runOptions are the arguments of the run command
+type runOptions struct {
+	file      string
+	function  string
+	args      []namedArg
+	input     string
+	output    string
+	blockType string
+}
+
+// namedArg is a parameter given on the command line
+type namedArg struct {
+	name  string
+	value string
+	json  bool
+}
+
+// argFlag collects repeated --arg or --argjson flags
+type argFlag struct {
+	args *[]namedArg
+	json bool
+}
+
+func (f argFlag) String() string {
+	return ""
+}
+
+func (f argFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected NAME=VALUE, got %q", s)
+	}
+	*f.args = append(*f.args, namedArg{name: name, value: value, json: f.json})
+	return nil
+}
+
+// parseRunFlags parses the arguments of the run command. Flags may come
+// before, between or after the file and function names.
+func parseRunFlags(args []string) (*runOptions, error) {
+	opts := &runOptions{}
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Var(argFlag{args: &opts.args}, "arg", "")
+	fs.Var(argFlag{args: &opts.args, json: true}, "argjson", "")
+	fs.StringVar(&opts.input, "input", "string", "")
+	fs.StringVar(&opts.output, "output", "json", "")
+	fs.StringVar(&opts.blockType, "block-type", "jq", "")
+
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(positional) != 2 {
+		return nil, fmt.Errorf("expected a file and a function name, got %q", positional)
+	}
+	opts.file, opts.function = positional[0], positional[1]
+	if opts.input != "string" && opts.input != "value" {
+		return nil, fmt.Errorf(`--input must be "string" or "value", got %q`, opts.input)
+	}
+	if opts.output != "json" && opts.output != "hcl" {
+		return nil, fmt.Errorf(`--output must be "json" or "hcl", got %q`, opts.output)
+	}
+	return opts, nil
+}
+
+// call decodes the functions of the file and calls the chosen one, adding
+// the sources of diagnostics to files
+func call(opts *runOptions, input []byte, files map[string]*hcl.File) (cty.Value, hcl.Diagnostics) {
+	parser := hclparse.NewParser()
+	var file *hcl.File
+	var diags hcl.Diagnostics
+	if strings.HasSuffix(opts.file, ".json") {
+		file, diags = parser.ParseJSONFile(opts.file)
+	} else {
+		file, diags = parser.ParseHCLFile(opts.file)
+	}
+	for name, f := range parser.Files() {
+		files[name] = f
+	}
+	if diags.HasErrors() {
+		return cty.NilVal, diags
+	}
+
+	functions, _, decodeDiags := jqfunc.DecodeJqFunctions(file.Body, opts.blockType)
+	diags = diags.Extend(decodeDiags)
+	if diags.HasErrors() {
+		return cty.NilVal, diags
+	}
+	fn, ok := functions[opts.function]
+	if !ok {
+		return cty.NilVal, diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Unknown function",
+			Detail:   fmt.Sprintf("%s defines no %s function %q; it defines %s", opts.file, opts.blockType, opts.function, functionList(functions)),
+		})
+	}
+
+	args, argDiags := callArgs(fn, opts, input, files)
+	diags = diags.Extend(argDiags)
+	if diags.HasErrors() {
+		return cty.NilVal, diags
+	}
+
+	result, err := fn.Call(args)
+	if err != nil {
+		return cty.NilVal, diags.Append(callDiagnostic(opts.function, err))
+	}
+	return result, diags
+}
+
+// callArgs builds the arguments of the function from the input and the
+// parameters given on the command line
+func callArgs(fn function.Function, opts *runOptions, input []byte, files map[string]*hcl.File) ([]cty.Value, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+
+	inputVal := cty.StringVal(string(input))
+	if opts.input == "value" {
+		var err error
+		if inputVal, err = jsonValue(input); err != nil {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid input",
+				Detail:   fmt.Sprintf("standard input is not valid JSON: %v", err),
+			})
+		}
+	}
+
+	// The parameters follow the input
+	params := fn.Params()[1:]
+	values := make(map[string]cty.Value, len(opts.args))
+	for _, arg := range opts.args {
+		known := false
+		for _, param := range params {
+			known = known || param.Name == arg.name
+		}
+		if !known {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Unknown parameter",
+				Detail:   fmt.Sprintf("function %s has no parameter %q; its parameters are %s", opts.function, arg.name, paramList(params)),
+			})
+			continue
+		}
+		val, argDiags := argValue(arg, files)
+		diags = diags.Extend(argDiags)
+		values[arg.name] = val
+	}
+
+	args := []cty.Value{inputVal}
+	for _, param := range params {
+		val, ok := values[param.Name]
+		if !ok {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Missing parameter",
+				Detail:   fmt.Sprintf("function %s needs a value for parameter %q; give it with --arg or --argjson", opts.function, param.Name),
+			})
+		}
+		args = append(args, val)
+	}
+	return args, diags
+}
+
+// argValue evaluates a parameter given on the command line. HCL
+// expressions are evaluated without variables or functions, and are kept
+// in files under the name "<arg NAME>" for diagnostics.
+func argValue(arg namedArg, files map[string]*hcl.File) (cty.Value, hcl.Diagnostics) {
+	if arg.json {
+		val, err := jsonValue([]byte(arg.value))
+		if err != nil {
+			return cty.DynamicVal, hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid --argjson value",
+				Detail:   fmt.Sprintf("the value of %s is not valid JSON: %v", arg.name, err),
+			}}
+		}
+		return val, nil
+	}
+
+	filename := fmt.Sprintf("<arg %s>", arg.name)
+	src := []byte(arg.value)
+	files[filename] = &hcl.File{Bytes: src}
+	expr, diags := hclsyntax.ParseExpression(src, filename, hcl.InitialPos)
+	if diags.HasErrors() {
+		return cty.DynamicVal, diags
+	}
+	val, valDiags := expr.Value(nil)
+	return val, diags.Extend(valDiags)
+}
+
+// jsonValue parses JSON into a cty value of the type it implies
+func jsonValue(data []byte) (cty.Value, error) {
+	ty, err := ctyjson.ImpliedType(data)
+	if err != nil {
+		return cty.DynamicVal, err
+	}
+	return ctyjson.Unmarshal(data, ty)
+}
+
+// callDiagnostic describes a failed call, pointing at the function's block
+// when the error gives it
+func callDiagnostic(name string, err error) *hcl.Diagnostic {
+	diag := &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  fmt.Sprintf("Call to function %q failed", name),
+		Detail:   err.Error(),
+	}
+	var jqErr *jqfunc.JqExecutionError
+	if errors.As(err, &jqErr) && jqErr.Range.Filename != "" {
+		diag.Detail = jqErr.Cause.Error()
+		diag.Subject = jqErr.Range.Ptr()
+	}
+	return diag
+}
+
+// formatResult renders a result for output, ending with a newline
+func formatResult(result cty.Value, output string) ([]byte, error) {
+	result, _ = result.UnmarkDeep()
+
+	var text []byte
+	switch {
+	case output == "hcl":
+		text = hclwrite.Format(hclwrite.TokensForValue(result).Bytes())
+	case result.Type() == cty.String && result.IsKnown() && !result.IsNull():
+		// The function returns JSON for JSON string input and strings as
+		// they are
+		text = []byte(result.AsString())
+	default:
+		var err error
+		if text, err = ctyjson.Marshal(result, result.Type()); err != nil {
+			return nil, fmt.Errorf("encoding result: %v", err)
+		}
+	}
+	if len(text) == 0 || text[len(text)-1] != '\n' {
+		text = append(text, '\n')
+	}
+	return text, nil
+}
+
+// functionList lists the names of functions for messages
+func functionList(functions map[string]function.Function) string {
+	if len(functions) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// paramList lists the names of parameters for messages
+func paramList(params []function.Parameter) string {
+	if len(params) == 0 {
+		return "none"
+	}
+	names := make([]string, len(params))
+	for i, param := range params {
+		names[i] = param.Name
+	}
+	return strings.Join(names, ", ")
+}
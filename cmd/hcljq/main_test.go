@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `
+jq "get_name" {
+    params = [rate]
+    query  = "{name: .name, price: (.price * (1 + $rate))}"
+}
+
+jq "name" {
+    query = ".name"
+}
+
+jq "typed" {
+    return_type = object({name = string, tags = list(string)})
+    query       = "{name, tags}"
+}
+
+jq "fails" {
+    query = "error(\"boom\")"
+}
+`
+
+// runTest runs the command on a file holding config and returns the exit
+// status and what it printed
+func runTest(t *testing.T, config, input string, args ...string) (int, string, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.hcl")
+	require.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+	for i, arg := range args {
+		args[i] = strings.ReplaceAll(arg, "FILE", path)
+	}
+
+	var stdout, stderr bytes.Buffer
+	status := run(args, strings.NewReader(input), &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		args     []string
+		expected string
+	}{
+		{"hcl argument", `{"name": "x", "price": 100}`, []string{"run", "FILE", "get_name", "--arg", "rate=0.08"},
+			"{\"name\":\"x\",\"price\":108}\n"},
+		{"json argument", `{"name": "x", "price": 100}`, []string{"run", "--argjson", "rate=0.5", "FILE", "get_name"},
+			"{\"name\":\"x\",\"price\":150}\n"},
+		{"string result", `{"name": "x"}`, []string{"run", "FILE", "name"}, "x\n"},
+		{"hcl output", `{"name": "x", "tags": ["a", "b"], "other": 1}`, []string{"run", "FILE", "typed", "--output", "hcl"},
+			"{\n  name = \"x\"\n  tags = [\"a\", \"b\"]\n}\n"},
+		{"value input", `{"name": "x", "price": 10}`, []string{"run", "FILE", "get_name", "--input=value", "--output=hcl", "--arg", "rate=1 + 1"},
+			"{\n  name  = \"x\"\n  price = 30\n}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, stdout, stderr := runTest(t, testConfig, tt.input, tt.args...)
+			require.Equal(t, exitOK, status, "stderr: %s", stderr)
+			assert.Equal(t, tt.expected, stdout)
+		})
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		args     []string
+		status   int
+		expected []string
+	}{
+		{"call fails", testConfig, []string{"run", "FILE", "fails"}, exitFailure,
+			[]string{`Error: Call to function "fails" failed`, `in jq "fails"`, "error: boom"}},
+		{"invalid config", "jq \"broken\" {\n    query = \n}\n", []string{"run", "FILE", "broken"}, exitFailure,
+			[]string{"Error: Invalid expression", "line 2"}},
+		{"invalid query", "jq \"broken\" {\n    query = \".[\"\n}\n", []string{"run", "FILE", "broken"}, exitFailure,
+			[]string{"Error: Invalid jq query", `in jq "broken"`}},
+		{"unknown function", testConfig, []string{"run", "FILE", "nope"}, exitFailure,
+			[]string{`function "nope"; it defines fails, get_name, name, typed`}},
+		{"invalid argument", testConfig, []string{"run", "FILE", "get_name", "--arg", "rate=1 +"}, exitFailure,
+			[]string{"on <arg rate> line 1", "1: 1 +"}},
+		{"invalid json argument", testConfig, []string{"run", "FILE", "get_name", "--argjson", "rate=x"}, exitFailure,
+			[]string{"the value of rate is not valid JSON"}},
+		{"missing argument", testConfig, []string{"run", "FILE", "get_name"}, exitFailure,
+			[]string{`needs a value for parameter "rate"`}},
+		{"unknown argument", testConfig, []string{"run", "FILE", "name", "--arg", "rate=1"}, exitFailure,
+			[]string{`function name has no parameter "rate"; its parameters are none`}},
+		{"no command", testConfig, nil, exitUsage, []string{"Usage: hcljq run"}},
+		{"missing function name", testConfig, []string{"run", "FILE"}, exitUsage, []string{"expected a file and a function name"}},
+		{"bad output flag", testConfig, []string{"run", "FILE", "name", "--output", "xml"}, exitUsage, []string{`--output must be "json" or "hcl"`}},
+		{"bad arg flag", testConfig, []string{"run", "FILE", "get_name", "--arg", "rate"}, exitUsage, []string{`expected NAME=VALUE, got "rate"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, stdout, stderr := runTest(t, tt.config, `{"name": "x", "price": 1}`, tt.args...)
+			assert.Equal(t, tt.status, status)
+			assert.Empty(t, stdout)
+			for _, expected := range tt.expected {
+				assert.Contains(t, stderr, expected)
+			}
+		})
+	}
+}
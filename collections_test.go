@@ -0,0 +1,163 @@
+package jqfunc
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestCollectionKinds(t *testing.T) {
+	hclCode := `
+jqfunction "identity" {
+    params = []
+    query = "."
+}
+
+jqfunction "infer" {
+    params = []
+    query = "."
+    infer_collections = true
+}
+
+jqfunction "typed_users" {
+    params = []
+    query = "[.[] | {name: .name, tags: (.tags // [])}]"
+    return_type = list(object({name = string, tags = list(string)}))
+}
+
+jqfunction "typed_map" {
+    params = []
+    query = "."
+    return_type = map(string)
+}
+
+jqfunction "typed_count" {
+    params = []
+    query = "length"
+    return_type = number
+}
+`
+	parser := hclparse.NewParser()
+	file, diags := parser.ParseHCL([]byte(hclCode), "collections.hcl")
+	require.False(t, diags.HasErrors(), "HCL parsing should succeed: %s", diags)
+
+	functions, _, diags := DecodeJqFunctions(file.Body, "jqfunction")
+	require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+
+	users := cty.ListVal([]cty.Value{
+		cty.ObjectVal(map[string]cty.Value{
+			"name": cty.StringVal("alice"),
+			"tags": cty.ListVal([]cty.Value{cty.StringVal("admin")}),
+		}),
+		cty.ObjectVal(map[string]cty.Value{
+			"name": cty.StringVal("bob"),
+			"tags": cty.ListValEmpty(cty.String),
+		}),
+	})
+
+	t.Run("default conversion yields tuple for unifiable elements", func(t *testing.T) {
+		result, err := functions["identity"].Call([]cty.Value{users})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.Type().IsTupleType(), "Got %s", result.Type().FriendlyName())
+	})
+
+	t.Run("inferred list of objects", func(t *testing.T) {
+		result, err := functions["infer"].Call([]cty.Value{users})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, users.Type(), result.Type(), "Got %s", result.Type().FriendlyName())
+		assert.True(t, result.Equals(users).True(), "Value should round trip")
+	})
+
+	t.Run("inferred map with null values", func(t *testing.T) {
+		input := cty.ObjectVal(map[string]cty.Value{
+			"a": cty.StringVal("x"),
+			"b": cty.NullVal(cty.String),
+		})
+
+		result, err := functions["infer"].Call([]cty.Value{input})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, cty.Map(cty.String), result.Type())
+		assert.True(t, result.Index(cty.StringVal("b")).IsNull())
+	})
+
+	t.Run("numbers and strings do not unify", func(t *testing.T) {
+		input := cty.TupleVal([]cty.Value{cty.NumberIntVal(1), cty.StringVal("a")})
+
+		result, err := functions["infer"].Call([]cty.Value{input})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.Type().IsTupleType(), "Got %s", result.Type().FriendlyName())
+	})
+
+	t.Run("objects with different attributes stay a tuple", func(t *testing.T) {
+		input := cty.TupleVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{"a": cty.NumberIntVal(1), "b": cty.True}),
+			cty.ObjectVal(map[string]cty.Value{"a": cty.NumberIntVal(1), "c": cty.True}),
+		})
+
+		result, err := functions["infer"].Call([]cty.Value{input})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.Type().IsTupleType(), "Got %s", result.Type().FriendlyName())
+	})
+
+	t.Run("declared return type from JSON input", func(t *testing.T) {
+		input := `[{"name": "alice", "tags": ["admin"]}, {"name": "bob"}]`
+
+		result, err := functions["typed_users"].Call([]cty.Value{cty.StringVal(input)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, users.Type(), result.Type())
+		assert.True(t, result.Equals(users).True(), "Got %#v", result)
+	})
+
+	t.Run("declared return type is the static type", func(t *testing.T) {
+		ty, err := functions["typed_map"].ReturnType([]cty.Type{cty.String})
+		require.NoError(t, err)
+		assert.Equal(t, cty.Map(cty.String), ty)
+	})
+
+	t.Run("declared map type converts primitives", func(t *testing.T) {
+		input := cty.ObjectVal(map[string]cty.Value{
+			"port":    cty.NumberIntVal(8080),
+			"enabled": cty.True,
+		})
+
+		result, err := functions["typed_map"].Call([]cty.Value{input})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.RawEquals(cty.MapVal(map[string]cty.Value{
+			"port":    cty.StringVal("8080"),
+			"enabled": cty.StringVal("true"),
+		})), "Got %#v", result)
+	})
+
+	t.Run("declared number type with JSON input", func(t *testing.T) {
+		result, err := functions["typed_count"].Call([]cty.Value{cty.StringVal(`[1, 2, 3]`)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.RawEquals(cty.NumberIntVal(3)), "Got %#v", result)
+	})
+
+	t.Run("result that does not match declared type", func(t *testing.T) {
+		_, err := functions["typed_map"].Call([]cty.Value{cty.StringVal(`[1, 2]`)})
+		require.Error(t, err, "Should fail to convert a tuple to a map")
+		var jqErr *JqExecutionError
+		require.ErrorAs(t, err, &jqErr)
+		assert.Contains(t, err.Error(), "declared return type map(string)")
+	})
+}
+
+func TestReturnTypeErrors(t *testing.T) {
+	hclCode := `
+jqfunction "bad" {
+    query = "."
+    return_type = lizt(string)
+}
+`
+	parser := hclparse.NewParser()
+	file, diags := parser.ParseHCL([]byte(hclCode), "collections.hcl")
+	require.False(t, diags.HasErrors(), "HCL parsing should succeed: %s", diags)
+
+	functions, _, diags := DecodeJqFunctions(file.Body, "jqfunction")
+	require.True(t, diags.HasErrors(), "Invalid type expression should be rejected")
+	assert.Empty(t, functions, "No functions should be created")
+}
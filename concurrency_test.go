@@ -0,0 +1,212 @@
+package jqfunc
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// These tests are most useful with the race detector: go test -race
+
+// runParallel calls fn from several goroutines, several times each
+func runParallel(t *testing.T, fn func(worker, iteration int)) {
+	t.Helper()
+	const workers, iterations = 16, 50
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				fn(w, i)
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	body := parseTestBody(t, `
+jq "total" {
+    params = [extra]
+    query = "[.[].n] | add + $extra"
+}
+
+jq "names" {
+    query = "[.[].name]"
+    infer_collections = true
+    sensitive = true
+}
+
+jq "evens" {
+    query = ".[] | select(. % 2 == 0)"
+    results = "array"
+}
+
+jq "first_even" {
+    query = ".[] | select(. % 2 == 0)"
+    results = "first"
+}
+
+jq "forever" {
+    query = "last(repeat(1))"
+    timeout = "10ms"
+}
+`)
+	functions, _, diags := DecodeJqFunctionsWithOptions(body)
+	require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+
+	t.Run("json string input", func(t *testing.T) {
+		runParallel(t, func(w, i int) {
+			input := fmt.Sprintf(`[{"n": %d}, {"n": %d}]`, w, i)
+			result, err := functions["total"].Call([]cty.Value{cty.StringVal(input), cty.NumberIntVal(1)})
+			if assert.NoError(t, err) {
+				assert.Equal(t, fmt.Sprint(w+i+1), result.AsString())
+			}
+		})
+	})
+
+	t.Run("shared cty input", func(t *testing.T) {
+		// The same input value is passed to every call
+		input := cty.ListVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("a").Mark("private")}),
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("b")}),
+		})
+		expected := cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}).
+			WithMarks(cty.NewValueMarks("private", SensitiveMark))
+
+		runParallel(t, func(w, i int) {
+			result, err := functions["names"].Call([]cty.Value{input})
+			if assert.NoError(t, err) {
+				assert.True(t, result.RawEquals(expected), "Got %#v", result)
+			}
+		})
+	})
+
+	t.Run("shared big number argument", func(t *testing.T) {
+		input := cty.StringVal(`[{"n": 1}]`)
+		extra := cty.MustParseNumberVal("123456789012345678901234567890")
+
+		runParallel(t, func(w, i int) {
+			result, err := functions["total"].Call([]cty.Value{input, extra})
+			if assert.NoError(t, err) {
+				assert.Equal(t, "123456789012345678901234567891", result.AsString())
+			}
+		})
+	})
+
+	t.Run("result modes", func(t *testing.T) {
+		runParallel(t, func(w, i int) {
+			input := cty.StringVal(fmt.Sprintf(`[%d, %d, %d]`, 2*w+1, 2*i, 2*i+2))
+
+			result, err := functions["evens"].Call([]cty.Value{input})
+			if assert.NoError(t, err) {
+				assert.Equal(t, fmt.Sprintf("[%d,%d]", 2*i, 2*i+2), result.AsString())
+			}
+
+			result, err = functions["first_even"].Call([]cty.Value{input})
+			if assert.NoError(t, err) {
+				assert.Equal(t, fmt.Sprint(2*i), result.AsString())
+			}
+		})
+	})
+
+	t.Run("timeouts", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for w := 0; w < 8; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := functions["forever"].Call([]cty.Value{cty.StringVal(`null`)})
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), "timed out after 10ms")
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func TestConcurrentSharedCode(t *testing.T) {
+	// Functions compiled through a shared cache share their compiled code
+	cache := NewCompileCache(8)
+	var funcs []function.Function
+	for i := 0; i < 4; i++ {
+		fn, err := Compile(fmt.Sprintf("f%d", i), "map(. * $k)", []string{"k"}, WithCompileCache(cache))
+		require.NoError(t, err)
+		funcs = append(funcs, fn.Function())
+	}
+	require.Equal(t, uint64(3), cache.Stats().Hits)
+
+	runParallel(t, func(w, i int) {
+		fn := funcs[(w+i)%len(funcs)]
+		input := cty.ListVal([]cty.Value{cty.NumberIntVal(int64(w)), cty.NumberIntVal(int64(i))})
+
+		result, err := fn.Call([]cty.Value{input, cty.NumberIntVal(3)})
+		if assert.NoError(t, err) {
+			expected := cty.ListVal([]cty.Value{cty.NumberIntVal(int64(3 * w)), cty.NumberIntVal(int64(3 * i))})
+			assert.True(t, result.RawEquals(expected), "Got %#v", result)
+		}
+	})
+}
+
+func TestConcurrentDecodingAndRegistration(t *testing.T) {
+	cache := NewCompileCache(4)
+	registry := NewConverterRegistry()
+	registry.Register(testPrefixType, CapsuleConverter{
+		Name: "prefix",
+		ToJq: func(encapsulated interface{}) (interface{}, error) {
+			return encapsulated.(*netip.Prefix).String(), nil
+		},
+	})
+	prefix := prefixVal("10.0.0.0/8")
+
+	runParallel(t, func(w, i int) {
+		if w == 0 {
+			// Registration may happen while other functions run
+			other := cty.Capsule(fmt.Sprintf("other%d", i), reflect.TypeOf(0))
+			registry.Register(other, CapsuleConverter{
+				ToJq: func(interface{}) (interface{}, error) { return 0, nil },
+			})
+			return
+		}
+
+		body := parseTestBody(t, fmt.Sprintf(`
+jq "bits" {
+    query = "split(\"/\")[1] | tonumber + %d"
+}
+`, i%6))
+		lib, _, diags := DecodeLibrary(body, WithCompileCache(cache), WithConverters(registry))
+		if !assert.False(t, diags.HasErrors(), "Library decoding should succeed: %s", diags) {
+			return
+		}
+
+		fn, _ := lib.Lookup("bits")
+		result, err := fn.Function().Call([]cty.Value{prefix})
+		if assert.NoError(t, err) {
+			assert.True(t, result.RawEquals(cty.NumberIntVal(int64(8+i%6))), "Got %#v", result)
+		}
+	})
+}
+
+func BenchmarkParallelCall(b *testing.B) {
+	fn, err := New("total", "[.[].n] | add", nil)
+	require.NoError(b, err)
+	input := cty.StringVal(`[{"n": 1}, {"n": 2}, {"n": 3}]`)
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := fn.Call([]cty.Value{input}); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,425 @@
+package jqfunc
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/itchyny/gojq"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+)
+
+// Values handed to gojq use only the types a gojq iterator can emit: nil,
+// bool, int, float64, *big.Int, string, []interface{} and
+// map[string]interface{}. Integers are kept exact (as int or *big.Int);
+// non-integral numbers are float64, as in jq itself.
+//
+// Conversion from cty is deterministic: lists and tuples keep their order,
+// and sets are sorted by jq's canonical ordering of values (the order used
+// by jq's sort). Conversion back to cty is guided by a type hint, usually
+// the declared return type or the static type of the input, which gives
+// empty arrays and objects their element type: [] with a list(T) or set(T)
+// hint becomes an empty list or set of T, {} with a map(T) hint becomes an
+// empty map of T, and without a usable hint they become cty.EmptyTupleVal
+// and cty.EmptyObjectVal.
+
+// converter converts values between cty and gojq
+type converter struct {
+	// inferCollections makes arrays and objects whose element types unify
+	// (see unifyTypes) become lists and maps rather than tuples and objects
+	inferCollections bool
+
+	// capsules holds the converters for capsule types
+	capsules *ConverterRegistry
+
+	// keyOrder records the key order of JSON string input for output that
+	// preserves it; it is nil otherwise
+	keyOrder *keyOrder
+
+	// decimals records the exact values of input numbers that a float64
+	// only approximates
+	decimals decimals
+
+	// capsuleHintsOnly makes type hints restore capsules without typing
+	// empty collections, for hints taken from the type of the input
+	capsuleHintsOnly bool
+}
+
+// ctyToJq converts an unmarked, known cty value to a gojq value
+func (c *converter) ctyToJq(val cty.Value) (interface{}, error) {
+	if val.IsNull() {
+		return nil, nil
+	}
+
+	ty := val.Type()
+	switch {
+	case ty == cty.String:
+		return val.AsString(), nil
+	case ty == cty.Bool:
+		return val.True(), nil
+	case ty == cty.Number:
+		exact := val.AsBigFloat()
+		result := bigFloatToJq(exact)
+		if f, ok := result.(float64); ok {
+			if _, accuracy := exact.Float64(); accuracy != big.Exact {
+				c.decimals.see(f, exact)
+			}
+		}
+		return result, nil
+	case ty.IsObjectType() || ty.IsMapType():
+		result := make(map[string]interface{}, val.LengthInt())
+		for it := val.ElementIterator(); it.Next(); {
+			key, elem := it.Element()
+			converted, err := c.ctyToJq(elem)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert attribute %s: %w", key.AsString(), err)
+			}
+			result[key.AsString()] = converted
+		}
+		return result, nil
+	case ty.IsListType() || ty.IsTupleType() || ty.IsSetType():
+		result := make([]interface{}, 0, val.LengthInt())
+		for it := val.ElementIterator(); it.Next(); {
+			_, elem := it.Element()
+			converted, err := c.ctyToJq(elem)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert element %d: %w", len(result), err)
+			}
+			result = append(result, converted)
+		}
+		if ty.IsSetType() {
+			sort.SliceStable(result, func(i, j int) bool {
+				return gojq.Compare(result[i], result[j]) < 0
+			})
+		}
+		return result, nil
+	case ty.IsCapsuleType():
+		conv, ok := c.capsules.Lookup(ty)
+		if !ok {
+			return nil, fmt.Errorf("no converter registered for capsule type %s", ty.FriendlyName())
+		}
+		converted, err := conv.ToJq(val.EncapsulatedValue())
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert %s: %w", ty.FriendlyName(), err)
+		}
+		result, err := normalizeGoValue(converted)
+		if err != nil {
+			return nil, fmt.Errorf("converter for %s returned %w", ty.FriendlyName(), err)
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("cannot convert value of type %s", ty.FriendlyName())
+	}
+}
+
+// bigFloatToJq converts a cty number to int or *big.Int when it is integral,
+// and to float64 otherwise
+func bigFloatToJq(f *big.Float) interface{} {
+	if f.IsInf() {
+		if f.Sign() < 0 {
+			return math.Inf(-1)
+		}
+		return math.Inf(1)
+	}
+	if !f.IsInt() {
+		result, _ := f.Float64()
+		return result
+	}
+	i, _ := f.Int(nil)
+	if i.IsInt64() {
+		if n := i.Int64(); math.MinInt <= n && n <= math.MaxInt {
+			return int(n)
+		}
+	}
+	return i
+}
+
+// jqToCty converts a gojq result to a cty value. Arrays whose elements all
+// have the same type become lists, otherwise tuples; objects whose values
+// all have the same type become maps, otherwise objects. The hint is the
+// type the result is expected to have, or cty.DynamicPseudoType if unknown;
+// it decides the type of empty arrays and objects, and where the hint is a
+// capsule type with a FromJq converter the result is converted back to it.
+// Non-empty arrays do not follow list and set hints, which are the declared
+// return type's to enforce.
+func (c *converter) jqToCty(v interface{}, hint cty.Type) (cty.Value, error) {
+	if hint.IsCapsuleType() {
+		if conv, ok := c.capsules.Lookup(hint); ok && conv.FromJq != nil {
+			if encapsulated, err := conv.FromJq(v); err == nil {
+				return cty.CapsuleVal(hint, encapsulated), nil
+			}
+		}
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return cty.NullVal(cty.DynamicPseudoType), nil
+	case bool:
+		return cty.BoolVal(v), nil
+	case string:
+		return cty.StringVal(v), nil
+	case int:
+		return cty.NumberIntVal(int64(v)), nil
+	case float64:
+		if math.IsNaN(v) {
+			// jq renders NaN as null, and cty cannot represent it
+			return cty.NullVal(cty.Number), nil
+		}
+		if exact := c.decimals.lookup(v); exact != nil {
+			return cty.NumberVal(new(big.Float).Copy(exact)), nil
+		}
+		return cty.NumberFloatVal(v), nil
+	case *big.Int:
+		return cty.NumberVal(new(big.Float).SetInt(v)), nil
+	case []interface{}:
+		if len(v) == 0 {
+			if c.capsuleHintsOnly {
+				return cty.EmptyTupleVal, nil
+			}
+			return emptyArray(hint), nil
+		}
+		elems := make([]cty.Value, len(v))
+		for i, elem := range v {
+			converted, err := c.jqToCty(elem, elementHint(hint, i, ""))
+			if err != nil {
+				return cty.NilVal, fmt.Errorf("failed to convert element %d: %w", i, err)
+			}
+			elems[i] = converted
+		}
+		if elems, ok := c.homogeneous(elems); ok {
+			return cty.ListVal(elems), nil
+		}
+		return cty.TupleVal(elems), nil
+	case map[string]interface{}:
+		if len(v) == 0 {
+			if c.capsuleHintsOnly {
+				return cty.EmptyObjectVal, nil
+			}
+			return emptyObject(hint), nil
+		}
+		keys := make([]string, 0, len(v))
+		elems := make([]cty.Value, 0, len(v))
+		for key, elem := range v {
+			converted, err := c.jqToCty(elem, elementHint(hint, -1, key))
+			if err != nil {
+				return cty.NilVal, fmt.Errorf("failed to convert attribute %s: %w", key, err)
+			}
+			keys = append(keys, key)
+			elems = append(elems, converted)
+		}
+		elems, homogeneous := c.homogeneous(elems)
+		attrs := make(map[string]cty.Value, len(v))
+		for i, key := range keys {
+			attrs[key] = elems[i]
+		}
+		if homogeneous {
+			return cty.MapVal(attrs), nil
+		}
+		return cty.ObjectVal(attrs), nil
+	default:
+		return cty.NilVal, fmt.Errorf("unsupported jq value of type %T", v)
+	}
+}
+
+// isEmptyCollection reports whether a value is a known, empty list, set,
+// tuple, map or object
+func isEmptyCollection(val cty.Value) bool {
+	ty := val.Type()
+	if !val.IsKnown() || val.IsNull() || !(ty.IsCollectionType() || ty.IsTupleType() || ty.IsObjectType()) {
+		return false
+	}
+	return val.LengthInt() == 0
+}
+
+// emptyArray returns the cty value for [] given a type hint
+func emptyArray(hint cty.Type) cty.Value {
+	switch {
+	case hint.IsListType() && hint.ElementType() != cty.DynamicPseudoType:
+		return cty.ListValEmpty(hint.ElementType())
+	case hint.IsSetType() && hint.ElementType() != cty.DynamicPseudoType:
+		return cty.SetValEmpty(hint.ElementType())
+	default:
+		return cty.EmptyTupleVal
+	}
+}
+
+// emptyObject returns the cty value for {} given a type hint
+func emptyObject(hint cty.Type) cty.Value {
+	if hint.IsMapType() && hint.ElementType() != cty.DynamicPseudoType {
+		return cty.MapValEmpty(hint.ElementType())
+	}
+	return cty.EmptyObjectVal
+}
+
+// elementHint returns the hint for an array element (by index) or object
+// value (by key) given the hint for the containing value
+func elementHint(hint cty.Type, index int, key string) cty.Type {
+	switch {
+	case hint.IsListType() || hint.IsSetType() || hint.IsMapType():
+		return hint.ElementType()
+	case hint.IsTupleType() && index >= 0 && index < len(hint.TupleElementTypes()):
+		return hint.TupleElementType(index)
+	case hint.IsObjectType() && index < 0 && hint.HasAttribute(key):
+		return hint.AttributeType(key)
+	default:
+		return cty.DynamicPseudoType
+	}
+}
+
+// homogeneous reports whether values can be the elements of a single
+// collection. Without inferCollections their types must be identical; with
+// it they must unify, and the returned values are converted to the unified
+// type.
+func (c *converter) homogeneous(values []cty.Value) ([]cty.Value, bool) {
+	if !c.inferCollections {
+		return values, allSameType(values)
+	}
+
+	ty := values[0].Type()
+	for _, v := range values[1:] {
+		var ok bool
+		if ty, ok = unifyTypes(ty, v.Type()); !ok {
+			return values, false
+		}
+	}
+	if ty == cty.DynamicPseudoType {
+		// Every element is an untyped null
+		return values, true
+	}
+
+	converted := make([]cty.Value, len(values))
+	for i, v := range values {
+		var err error
+		if converted[i], err = convert.Convert(v, ty); err != nil {
+			return values, false
+		}
+	}
+	return converted, true
+}
+
+// allSameType reports whether all values have exactly the same type
+func allSameType(values []cty.Value) bool {
+	for _, v := range values[1:] {
+		if !v.Type().Equals(values[0].Type()) {
+			return false
+		}
+	}
+	return true
+}
+
+// unifyTypes finds a type both a and b convert to without changing any
+// primitive value: untyped nulls (cty.DynamicPseudoType) unify with
+// anything, untyped empty arrays and objects unify with any list or map,
+// and lists, maps and objects with the same attributes unify when their
+// element or attribute types do. Unlike convert.Unify, a number and a
+// string do not unify.
+func unifyTypes(a, b cty.Type) (cty.Type, bool) {
+	switch {
+	case a.Equals(b):
+		return a, true
+	case a == cty.DynamicPseudoType:
+		return b, true
+	case b == cty.DynamicPseudoType:
+		return a, true
+	case a.Equals(cty.EmptyTuple) && b.IsListType(), a.Equals(cty.EmptyObject) && b.IsMapType():
+		return b, true
+	case b.Equals(cty.EmptyTuple) && a.IsListType(), b.Equals(cty.EmptyObject) && a.IsMapType():
+		return a, true
+	case a.IsListType() && b.IsListType():
+		elem, ok := unifyTypes(a.ElementType(), b.ElementType())
+		return cty.List(elem), ok
+	case a.IsMapType() && b.IsMapType():
+		elem, ok := unifyTypes(a.ElementType(), b.ElementType())
+		return cty.Map(elem), ok
+	case a.IsObjectType() && b.IsObjectType():
+		aAttrs, bAttrs := a.AttributeTypes(), b.AttributeTypes()
+		if len(aAttrs) != len(bAttrs) {
+			return cty.NilType, false
+		}
+		attrs := make(map[string]cty.Type, len(aAttrs))
+		for name, aType := range aAttrs {
+			bType, exists := bAttrs[name]
+			if !exists {
+				return cty.NilType, false
+			}
+			attrType, ok := unifyTypes(aType, bType)
+			if !ok {
+				return cty.NilType, false
+			}
+			attrs[name] = attrType
+		}
+		return cty.Object(attrs), true
+	default:
+		return cty.NilType, false
+	}
+}
+
+// jsonNumberToJq converts a JSON number literal. Integer literals are kept
+// exact; literals with a fraction or exponent become float64, as in jq.
+func jsonNumberToJq(n json.Number) interface{} {
+	if !strings.ContainsAny(string(n), ".eE") {
+		if i, ok := new(big.Int).SetString(string(n), 10); ok {
+			if i.IsInt64() && math.MinInt <= i.Int64() && i.Int64() <= math.MaxInt {
+				return int(i.Int64())
+			}
+			return i
+		}
+	}
+	f, _ := strconv.ParseFloat(string(n), 64)
+	return f
+}
+
+// normalizeGoValue converts a JSON-compatible Go value to the types gojq
+// works with, returning a copy
+func normalizeGoValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, bool, string, float64, *big.Int:
+		return v, nil
+	case int:
+		return v, nil
+	case int8, int16, int32, int64:
+		n := reflect.ValueOf(v).Int()
+		if math.MinInt <= n && n <= math.MaxInt {
+			return int(n), nil
+		}
+		return big.NewInt(n), nil
+	case uint, uint8, uint16, uint32, uint64:
+		n := reflect.ValueOf(v).Uint()
+		if n <= math.MaxInt {
+			return int(n), nil
+		}
+		return new(big.Int).SetUint64(n), nil
+	case float32:
+		return float64(v), nil
+	case json.Number:
+		return jsonNumberToJq(v), nil
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, elem := range v {
+			converted, err := normalizeGoValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = converted
+		}
+		return result, nil
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			converted, err := normalizeGoValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = converted
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("unsupported value of type %T", v)
+	}
+}
@@ -0,0 +1,225 @@
+package jqfunc
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestConversionMatrix(t *testing.T) {
+	hclCode := `
+jqfunction "identity" {
+    params = []
+    query = "."
+}
+
+jqfunction "compact" {
+    params = []
+    query = "tojson"
+}
+
+jqfunction "mark" {
+    params = []
+    query = "[.[] | \"x\"]"
+}
+
+jqfunction "lengths" {
+    params = []
+    query = "map(length)"
+}
+
+jqfunction "first" {
+    params = []
+    query = ".[0]"
+}
+
+jqfunction "typed_empty_set" {
+    params = []
+    query = "[]"
+    return_type = set(string)
+}
+`
+	parser := hclparse.NewParser()
+	file, diags := parser.ParseHCL([]byte(hclCode), "convert.hcl")
+	require.False(t, diags.HasErrors(), "HCL parsing should succeed: %s", diags)
+
+	functions, _, diags := DecodeJqFunctions(file.Body, "jqfunction")
+	require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+
+	bigNumber, _ := new(big.Float).SetPrec(512).SetString("123456789012345678901234567890")
+
+	// Strings are not in the matrix: a string input is parsed as JSON
+	tests := []struct {
+		name     string
+		input    cty.Value
+		expected cty.Value
+		json     string
+	}{
+		{"integer", cty.NumberIntVal(42), cty.NumberIntVal(42), `42`},
+		{"big integer", cty.NumberVal(bigNumber), cty.NumberVal(bigNumber), `123456789012345678901234567890`},
+		{"fraction", cty.NumberFloatVal(1.5), cty.NumberFloatVal(1.5), `1.5`},
+		{"bool", cty.True, cty.True, `true`},
+		{
+			"list",
+			cty.ListVal([]cty.Value{cty.StringVal("b"), cty.StringVal("a")}),
+			cty.ListVal([]cty.Value{cty.StringVal("b"), cty.StringVal("a")}),
+			`["b","a"]`,
+		},
+		{"empty list", cty.ListValEmpty(cty.String), cty.ListValEmpty(cty.String), `[]`},
+		{
+			"list with null",
+			cty.ListVal([]cty.Value{cty.StringVal("a"), cty.NullVal(cty.String)}),
+			cty.TupleVal([]cty.Value{cty.StringVal("a"), cty.NullVal(cty.DynamicPseudoType)}),
+			`["a",null]`,
+		},
+		{
+			"set",
+			cty.SetVal([]cty.Value{cty.StringVal("c"), cty.StringVal("a"), cty.StringVal("b")}),
+			cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b"), cty.StringVal("c")}),
+			`["a","b","c"]`,
+		},
+		{"empty set", cty.SetValEmpty(cty.Number), cty.SetValEmpty(cty.Number), `[]`},
+		{
+			"tuple",
+			cty.TupleVal([]cty.Value{cty.NumberIntVal(1), cty.StringVal("a")}),
+			cty.TupleVal([]cty.Value{cty.NumberIntVal(1), cty.StringVal("a")}),
+			`[1,"a"]`,
+		},
+		{"empty tuple", cty.EmptyTupleVal, cty.EmptyTupleVal, `[]`},
+		{
+			"map",
+			cty.MapVal(map[string]cty.Value{"x": cty.NumberIntVal(1), "y": cty.NumberIntVal(2)}),
+			cty.MapVal(map[string]cty.Value{"x": cty.NumberIntVal(1), "y": cty.NumberIntVal(2)}),
+			`{"x":1,"y":2}`,
+		},
+		{"empty map", cty.MapValEmpty(cty.String), cty.MapValEmpty(cty.String), `{}`},
+		{
+			"object",
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("a"), "n": cty.NumberIntVal(1)}),
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("a"), "n": cty.NumberIntVal(1)}),
+			`{"n":1,"name":"a"}`,
+		},
+		{"empty object", cty.EmptyObjectVal, cty.EmptyObjectVal, `{}`},
+		{
+			"null attribute",
+			cty.ObjectVal(map[string]cty.Value{"a": cty.StringVal("x"), "b": cty.NullVal(cty.String)}),
+			cty.ObjectVal(map[string]cty.Value{"a": cty.StringVal("x"), "b": cty.NullVal(cty.DynamicPseudoType)}),
+			`{"a":"x","b":null}`,
+		},
+		{
+			"nested empty list",
+			cty.ListVal([]cty.Value{
+				cty.ObjectVal(map[string]cty.Value{"id": cty.NumberIntVal(1), "tags": cty.ListValEmpty(cty.String)}),
+			}),
+			cty.ListVal([]cty.Value{
+				cty.ObjectVal(map[string]cty.Value{"id": cty.NumberIntVal(1), "tags": cty.EmptyTupleVal}),
+			}),
+			`[{"id":1,"tags":[]}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := functions["identity"].Call([]cty.Value{tt.input})
+			require.NoError(t, err, "Function call should succeed")
+			assert.True(t, result.RawEquals(tt.expected), "Expected %#v, got %#v", tt.expected, result)
+
+			result, err = functions["compact"].Call([]cty.Value{tt.input})
+			require.NoError(t, err, "Function call should succeed")
+			assert.Equal(t, tt.json, result.AsString())
+		})
+	}
+
+	t.Run("reshaping queries", func(t *testing.T) {
+		set := cty.SetVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")})
+		nested := cty.ListVal([]cty.Value{cty.ListValEmpty(cty.String), cty.ListVal([]cty.Value{cty.StringVal("x")})})
+
+		tests := []struct {
+			name     string
+			function string
+			input    cty.Value
+			expected cty.Value
+		}{
+			{"set mapped to equal values", "mark", set, cty.ListVal([]cty.Value{cty.StringVal("x"), cty.StringVal("x")})},
+			{"set mapped to numbers", "lengths", set, cty.ListVal([]cty.Value{cty.NumberIntVal(1), cty.NumberIntVal(1)})},
+			{"element of nested list", "first", nested, cty.EmptyTupleVal},
+			{"empty set mapped", "mark", cty.SetValEmpty(cty.String), cty.SetValEmpty(cty.String)},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				result, err := functions[tt.function].Call([]cty.Value{tt.input})
+				require.NoError(t, err, "Function call should succeed")
+				assert.True(t, result.RawEquals(tt.expected), "Expected %#v, got %#v", tt.expected, result)
+			})
+		}
+	})
+
+	t.Run("set of objects is sorted canonically", func(t *testing.T) {
+		input := cty.SetVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("carol")}),
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("alice")}),
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("bob")}),
+		})
+
+		for i := 0; i < 5; i++ {
+			result, err := functions["compact"].Call([]cty.Value{input})
+			require.NoError(t, err, "Function call should succeed")
+			assert.Equal(t, `[{"name":"alice"},{"name":"bob"},{"name":"carol"}]`, result.AsString())
+		}
+	})
+
+	t.Run("untyped empty array from JSON input", func(t *testing.T) {
+		result, err := functions["identity"].Call([]cty.Value{cty.StringVal(`[]`)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, "[]", result.AsString())
+	})
+
+	t.Run("empty collection typed by declared return type", func(t *testing.T) {
+		result, err := functions["typed_empty_set"].Call([]cty.Value{cty.StringVal(`null`)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.RawEquals(cty.SetValEmpty(cty.String)), "Got %#v", result)
+	})
+}
+
+func TestEmptyCollectionHints(t *testing.T) {
+	c := &converter{}
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		hint     cty.Type
+		expected cty.Value
+	}{
+		{"array without hint", []interface{}{}, cty.DynamicPseudoType, cty.EmptyTupleVal},
+		{"array with list hint", []interface{}{}, cty.List(cty.Bool), cty.ListValEmpty(cty.Bool)},
+		{"array with set hint", []interface{}{}, cty.Set(cty.Bool), cty.SetValEmpty(cty.Bool)},
+		{"array with list of any hint", []interface{}{}, cty.List(cty.DynamicPseudoType), cty.EmptyTupleVal},
+		{"object without hint", map[string]interface{}{}, cty.DynamicPseudoType, cty.EmptyObjectVal},
+		{"object with map hint", map[string]interface{}{}, cty.Map(cty.Number), cty.MapValEmpty(cty.Number)},
+		{"array with object hint", []interface{}{}, cty.EmptyObject, cty.EmptyTupleVal},
+		{
+			"nested array with tuple hint",
+			[]interface{}{[]interface{}{}},
+			cty.Tuple([]cty.Type{cty.List(cty.String)}),
+			cty.ListVal([]cty.Value{cty.ListValEmpty(cty.String)}),
+		},
+		{
+			"nested object with object hint",
+			map[string]interface{}{"m": map[string]interface{}{}},
+			cty.Object(map[string]cty.Type{"m": cty.Map(cty.String)}),
+			cty.MapVal(map[string]cty.Value{"m": cty.MapValEmpty(cty.String)}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := c.jqToCty(tt.value, tt.hint)
+			require.NoError(t, err)
+			assert.True(t, result.RawEquals(tt.expected), "Expected %#v, got %#v", tt.expected, result)
+		})
+	}
+}
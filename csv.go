@@ -0,0 +1,144 @@
+package jqfunc
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// CSVOptions configures the csv and tsv input formats. The zero value reads
+// a header row and keeps every field a string.
+type CSVOptions struct {
+	// NoHeader makes every row an array of fields. By default the first
+	// row names the fields and every other row becomes an object.
+	NoHeader bool
+
+	// Delimiter separates fields; zero means ',' for csv and a tab for tsv
+	Delimiter rune
+
+	// InferTypes turns fields that are JSON numbers or the literals true
+	// and false into numbers and booleans
+	InferTypes bool
+}
+
+// delimiter returns the field delimiter for the format
+func (opts CSVOptions) delimiter(format Format) rune {
+	switch {
+	case opts.Delimiter != 0:
+		return opts.Delimiter
+	case format == FormatTSV:
+		return '\t'
+	default:
+		return ','
+	}
+}
+
+// validDelimiter reports whether r can separate fields
+func validDelimiter(r rune) bool {
+	return r != 0 && r != '"' && r != '\r' && r != '\n' && utf8.ValidRune(r) && r != utf8.RuneError
+}
+
+// decodeCSV parses csv or tsv input into an array of rows. CSV fields may be
+// quoted as in RFC 4180; TSV fields are never quoted, so they cannot contain
+// tabs or line breaks. Empty lines are skipped, and so is a leading byte
+// order mark.
+func decodeCSV(data []byte, format Format, opts CSVOptions) (interface{}, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	delimiter := opts.delimiter(format)
+
+	var next func() ([]string, error)
+	if format == FormatTSV {
+		next = tsvReader(data, delimiter)
+	} else {
+		r := csv.NewReader(bytes.NewReader(data))
+		r.Comma = delimiter
+		r.FieldsPerRecord = -1 // checked below, to report the row
+		next = r.Read
+	}
+
+	var header []string
+	rows := []interface{}{}
+	for row := 1; ; row++ {
+		record, err := next()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, fmt.Errorf("row %d: line %d, column %d: %v", row, parseErr.Line, parseErr.Column, parseErr.Err)
+			}
+			return nil, fmt.Errorf("row %d: %v", row, err)
+		}
+
+		if opts.NoHeader {
+			fields := make([]interface{}, len(record))
+			for i, field := range record {
+				fields[i] = csvValue(field, opts.InferTypes)
+			}
+			rows = append(rows, fields)
+			continue
+		}
+
+		if header == nil {
+			seen := make(map[string]bool, len(record))
+			for i, name := range record {
+				if seen[name] {
+					return nil, fmt.Errorf("row %d, column %d: duplicate column name %q", row, i+1, name)
+				}
+				seen[name] = true
+			}
+			header = record
+			continue
+		}
+		if len(record) != len(header) {
+			return nil, fmt.Errorf("row %d: expected %d fields, got %d", row, len(header), len(record))
+		}
+		obj := make(map[string]interface{}, len(header))
+		for i, name := range header {
+			obj[name] = csvValue(record[i], opts.InferTypes)
+		}
+		rows = append(rows, obj)
+	}
+}
+
+// tsvReader returns a function reading the records of TSV data one line at a
+// time
+func tsvReader(data []byte, delimiter rune) func() ([]string, error) {
+	sep := string(delimiter)
+	lines := strings.Split(string(data), "\n")
+	return func() ([]string, error) {
+		for len(lines) > 0 {
+			line := strings.TrimSuffix(lines[0], "\r")
+			lines = lines[1:]
+			if line != "" {
+				return strings.Split(line, sep), nil
+			}
+		}
+		return nil, io.EOF
+	}
+}
+
+// csvValue converts a field, inferring its type when asked to
+func csvValue(field string, inferTypes bool) interface{} {
+	if !inferTypes {
+		return field
+	}
+	switch field {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if field != "" && (field[0] == '-' || ('0' <= field[0] && field[0] <= '9')) {
+		d := &jsonDecoder{data: []byte(field)}
+		if v, err := d.number(); err == nil && d.pos == len(d.data) {
+			return v
+		}
+	}
+	return field
+}
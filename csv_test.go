@@ -0,0 +1,188 @@
+package jqfunc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestDecodeCSV(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   Format
+		opts     CSVOptions
+		data     string
+		expected interface{}
+	}{
+		{"header row", FormatCSV, CSVOptions{}, "host,port\nweb1,80\nweb2,8080\n",
+			[]interface{}{
+				map[string]interface{}{"host": "web1", "port": "80"},
+				map[string]interface{}{"host": "web2", "port": "8080"},
+			}},
+		{"no header", FormatCSV, CSVOptions{NoHeader: true}, "a,1\nb,2,extra\n",
+			[]interface{}{[]interface{}{"a", "1"}, []interface{}{"b", "2", "extra"}}},
+		{"quoted fields", FormatCSV, CSVOptions{NoHeader: true}, "\"a,b\",\"say \"\"hi\"\"\",\"two\nlines\"\r\n",
+			[]interface{}{[]interface{}{"a,b", `say "hi"`, "two\nlines"}}},
+		{"delimiter", FormatCSV, CSVOptions{Delimiter: ';'}, "name;size\nx;1,5\n",
+			[]interface{}{map[string]interface{}{"name": "x", "size": "1,5"}}},
+		{"type inference", FormatCSV, CSVOptions{InferTypes: true}, "n,f,big,b,zip,s,empty\n-3,2.5e1,123456789012345678901234567890,true,007,1x,\n",
+			[]interface{}{map[string]interface{}{
+				"n": -3, "f": 25.0, "big": mustBigInt("123456789012345678901234567890"),
+				"b": true, "zip": "007", "s": "1x", "empty": "",
+			}}},
+		{"byte order mark and empty lines", FormatCSV, CSVOptions{}, "\xef\xbb\xbfid\n\n1\n\n",
+			[]interface{}{map[string]interface{}{"id": "1"}}},
+		{"header only", FormatCSV, CSVOptions{}, "a,b\n", []interface{}{}},
+		{"empty", FormatCSV, CSVOptions{}, "", []interface{}{}},
+		{"tsv", FormatTSV, CSVOptions{InferTypes: true}, "name\tcomment\r\nx\t\"quoted\", as is\r\ny\t\r\n",
+			[]interface{}{
+				map[string]interface{}{"name": "x", "comment": `"quoted", as is`},
+				map[string]interface{}{"name": "y", "comment": ""},
+			}},
+		{"tsv with delimiter", FormatTSV, CSVOptions{NoHeader: true, Delimiter: '|'}, "a|\"b\n",
+			[]interface{}{[]interface{}{"a", `"b`}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := decodeCSV([]byte(tt.data), tt.format, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, rows)
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		errorTests := []struct {
+			name     string
+			format   Format
+			data     string
+			expected string
+		}{
+			{"bare quote", FormatCSV, "a,b\n1,2\n3,x\"y\n", `row 3: line 3, column 4: bare " in non-quoted-field`},
+			{"unterminated quote", FormatCSV, "a\n\"open\n", `row 2: line 2, column 7: extraneous or missing " in quoted-field`},
+			{"too few fields", FormatCSV, "a,b,c\n1,2,3\n4,5\n", "row 3: expected 3 fields, got 2"},
+			{"too many fields", FormatTSV, "a\tb\n1\t2\t3\n", "row 2: expected 2 fields, got 3"},
+			{"duplicate column", FormatCSV, "id,name,id\n", `row 1, column 3: duplicate column name "id"`},
+		}
+		for _, tt := range errorTests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := decodeCSV([]byte(tt.data), tt.format, CSVOptions{})
+				require.Error(t, err)
+				assert.Equal(t, tt.expected, err.Error())
+			})
+		}
+	})
+}
+
+func TestCSVFormat(t *testing.T) {
+	body := parseTestBody(t, `
+jq "web_ports" {
+    input_format = "csv"
+    query        = "[.[] | select(.role == \"web\") | .port]"
+
+    csv {
+        infer_types = true
+    }
+}
+
+jq "columns" {
+    input_format = "tsv"
+    query        = "map(.[1])"
+
+    csv {
+        header = false
+    }
+}
+
+jq "semicolons" {
+    input_format = "csv"
+    return_type  = list(object({name = string, count = number}))
+    query        = "."
+
+    csv {
+        delimiter   = ";"
+        infer_types = true
+    }
+}
+`)
+	functions, _, diags := DecodeJqFunctions(body, "jq")
+	require.False(t, diags.HasErrors(), "Decoding should succeed: %s", diags)
+
+	t.Run("objects", func(t *testing.T) {
+		result, err := functions["web_ports"].Call([]cty.Value{cty.StringVal("host,role,port\na,web,80\nb,db,5432\nc,web,8080\n")})
+		require.NoError(t, err)
+		assert.Equal(t, cty.StringVal("[80,8080]"), result)
+	})
+
+	t.Run("arrays", func(t *testing.T) {
+		result, err := functions["columns"].Call([]cty.Value{cty.StringVal("a\t1\nb\t2\n")})
+		require.NoError(t, err)
+		assert.Equal(t, cty.StringVal(`["1","2"]`), result)
+	})
+
+	t.Run("return type", func(t *testing.T) {
+		result, err := functions["semicolons"].Call([]cty.Value{cty.StringVal("name;count\nx;1\ny;2\n")})
+		require.NoError(t, err)
+		assert.True(t, result.RawEquals(cty.ListVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("x"), "count": cty.NumberIntVal(1)}),
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("y"), "count": cty.NumberIntVal(2)}),
+		})), "Got %#v", result)
+	})
+
+	t.Run("malformed records", func(t *testing.T) {
+		_, err := functions["web_ports"].Call([]cty.Value{cty.StringVal("host,role,port\na,web\n")})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid CSV input: row 2: expected 3 fields, got 2")
+	})
+
+	t.Run("options", func(t *testing.T) {
+		fn, err := New("rows", "length", nil, WithInputFormat(FormatCSV), WithCSVOptions(CSVOptions{NoHeader: true}))
+		require.NoError(t, err)
+		result, err := fn.Call([]cty.Value{cty.StringVal("a\nb\n")})
+		require.NoError(t, err)
+		assert.Equal(t, cty.StringVal("2"), result)
+
+		_, err = New("rows", ".", nil, WithCSVOptions(CSVOptions{InferTypes: true}))
+		assert.EqualError(t, err, `jq function rows: csv options require input_format = "csv" or "tsv"`)
+	})
+
+	t.Run("invalid blocks", func(t *testing.T) {
+		body := parseTestBody(t, `
+jq "bad_delimiter" {
+    input_format = "csv"
+    query        = "."
+
+    csv {
+        delimiter = "::"
+    }
+}
+
+jq "two_blocks" {
+    input_format = "csv"
+    query        = "."
+
+    csv {
+        header = false
+    }
+    csv {
+        header = true
+    }
+}
+
+jq "not_csv" {
+    query = "."
+
+    csv {
+        header = false
+    }
+}
+`)
+		_, _, diags := DecodeJqFunctions(body, "jq")
+		require.Len(t, diags, 3)
+		assert.Equal(t, "delimiter must be a single character other than a quote or a line break", diags[0].Detail)
+		assert.Equal(t, "Duplicate csv block", diags[1].Summary)
+		assert.Equal(t, `csv options require input_format = "csv" or "tsv"`, diags[2].Detail)
+	})
+}
@@ -0,0 +1,92 @@
+package jqfunc
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// decimals records the exact values of the non-integral numbers in the input
+// of a call that a float64 only approximates, such as money amounts with
+// more than 17 digits, since gojq holds them as float64. A result number
+// equal to one of those float64 values is written, or converted to cty, with
+// the exact value again, so that numbers a query passes through keep their
+// precision. A float64 that two different input numbers round to is left as
+// it is.
+type decimals struct {
+	// exact maps float64 values to the exact values they approximate, or
+	// to nil when more than one input number rounds to the value
+	exact map[float64]*big.Float
+}
+
+// see records the exact value of an input number that was rounded to f
+func (d *decimals) see(f float64, exact *big.Float) {
+	if d == nil || f == 0 || math.IsInf(f, 0) {
+		return
+	}
+	if d.exact == nil {
+		d.exact = make(map[float64]*big.Float)
+	}
+	if prev, seen := d.exact[f]; seen {
+		if prev != nil && prev.Cmp(exact) != 0 {
+			d.exact[f] = nil
+		}
+		return
+	}
+	d.exact[f] = exact
+}
+
+// seeLiteral records the exact value of a decimal number literal that was
+// parsed as f, if f does not read back as the same digits
+func (d *decimals) seeLiteral(f float64, literal string) {
+	if d == nil || f == 0 || math.IsInf(f, 0) {
+		return
+	}
+	digits, point, ok := decimalDigits(literal)
+	if !ok {
+		return
+	}
+	if fDigits, fPoint, _ := decimalDigits(strconv.FormatFloat(f, 'e', -1, 64)); digits == fDigits && point == fPoint {
+		return
+	}
+	exact, _, err := big.ParseFloat(literal, 10, 512, big.ToNearestEven)
+	if err != nil {
+		return
+	}
+	d.see(f, exact)
+}
+
+// lookup returns the exact value recorded for f, or nil
+func (d *decimals) lookup(f float64) *big.Float {
+	if d == nil {
+		return nil
+	}
+	return d.exact[f]
+}
+
+// decimalDigits returns the significant digits of a positive or negative
+// decimal literal, without leading or trailing zeros, and the number of
+// digits before the decimal point, which is negative when the point comes
+// before leading zeros
+func decimalDigits(literal string) (string, int, bool) {
+	literal = strings.TrimLeft(literal, "+-")
+	mantissa, exponent, hasExponent := strings.Cut(strings.ToLower(literal), "e")
+	point := strings.IndexByte(mantissa, '.')
+	if point < 0 {
+		point = len(mantissa)
+	} else {
+		mantissa = mantissa[:point] + mantissa[point+1:]
+	}
+	if hasExponent {
+		exp, err := strconv.Atoi(exponent)
+		if err != nil {
+			return "", 0, false
+		}
+		point += exp
+	}
+
+	digits := strings.TrimLeft(mantissa, "0")
+	point -= len(mantissa) - len(digits)
+	return strings.TrimRight(digits, "0"), point, true
+}
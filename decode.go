@@ -0,0 +1,513 @@
+package jqfunc
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/gocty"
+)
+
+// DecodeJqFunctions extracts and compiles jq function blocks from HCL bodies, returning HCL functions
+// Similar to userfunc.DecodeUserFunctions but for jq functions
+func DecodeJqFunctions(body hcl.Body, blockType string) (map[string]function.Function, hcl.Body, hcl.Diagnostics) {
+	return DecodeJqFunctionsWithOptions(body, WithBlockType(blockType))
+}
+
+// DecodeJqFunctionsWithOptions is DecodeJqFunctions configured by options.
+// Besides the block type (WithBlockType, "jq" by default) the options set
+// the EvalContext for block attributes, gojq compiler options, defaults for
+// the settings of every decoded function, and diagnostic policies.
+func DecodeJqFunctionsWithOptions(body hcl.Body, opts ...Option) (map[string]function.Function, hcl.Body, hcl.Diagnostics) {
+	lib, remainingBody, diags := DecodeLibrary(body, opts...)
+	if lib == nil {
+		return nil, remainingBody, diags
+	}
+	return lib.FunctionMap(), remainingBody, diags
+}
+
+// decodeJqFunctions decodes and compiles the function blocks in body,
+// returning them in definition order
+func decodeJqFunctions(body hcl.Body, cfg *config) ([]*JqFunction, hcl.Body, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+
+	// Define the schema for the specified block type
+	schema := &hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{
+			{
+				Type:       cfg.blockType,
+				LabelNames: []string{"name"},
+			},
+		},
+	}
+
+	// Extract jqfunction blocks
+	content, remainingBody, contentDiags := body.PartialContent(schema)
+	diags = diags.Extend(contentDiags)
+	if diags.HasErrors() {
+		return nil, nil, diags
+	}
+
+	var jqFuncs []*JqFunction
+	byName := make(map[string]int)
+
+	// Process each block of the specified type
+	for _, block := range content.Blocks {
+		if block.Type != cfg.blockType {
+			continue
+		}
+
+		funcDef, blockDiags := decodeJqBlock(block, cfg)
+		diags = diags.Extend(blockDiags)
+		if blockDiags.HasErrors() {
+			continue
+		}
+
+		compiledFunc, compileDiags := compileJqFunction(funcDef)
+		diags = diags.Extend(compileDiags)
+		if compileDiags.HasErrors() {
+			continue // Skip this function but continue with others
+		}
+
+		// Apply the duplicate name policy
+		if i, exists := byName[compiledFunc.Name]; exists {
+			if cfg.duplicates != DuplicatesReplace {
+				severity := hcl.DiagWarning
+				if cfg.duplicates == DuplicatesError {
+					severity = hcl.DiagError
+				}
+				diags = diags.Append(&hcl.Diagnostic{
+					Severity: severity,
+					Summary:  fmt.Sprintf("Duplicate %s function", cfg.blockType),
+					Detail:   fmt.Sprintf("A function named %q was already defined at %s", compiledFunc.Name, jqFuncs[i].Range),
+					Subject:  &block.DefRange,
+				})
+			}
+			if cfg.duplicates != DuplicatesError {
+				jqFuncs[i] = compiledFunc
+			}
+			continue
+		}
+
+		byName[compiledFunc.Name] = len(jqFuncs)
+		jqFuncs = append(jqFuncs, compiledFunc)
+	}
+
+	if !cfg.partialResults && diags.HasErrors() {
+		return nil, remainingBody, diags
+	}
+	if jqFuncs == nil {
+		jqFuncs = []*JqFunction{}
+	}
+	return jqFuncs, remainingBody, diags
+}
+
+// decodeJqBlock decodes the definition in a single function block. Settings
+// not given in the block are taken from cfg.
+func decodeJqBlock(block *hcl.Block, cfg *config) (*jqFunctionDef, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	blockType := block.Type
+
+	// Ensure we have exactly one label (the function name)
+	if len(block.Labels) != 1 {
+		diags = diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Invalid %s block", blockType),
+			Detail:   fmt.Sprintf("%s blocks must have exactly one label (the function name)", blockType),
+			Subject:  &block.DefRange,
+		})
+		return nil, diags
+	}
+
+	// Define schema for the block body to get params and query
+	bodySchema := &hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{
+			{Name: "params", Required: false},
+			{Name: "query", Required: true},
+			{Name: "sensitive", Required: false},
+			{Name: "infer_collections", Required: false},
+			{Name: "return_type", Required: false},
+			{Name: "results", Required: false},
+			{Name: "timeout", Required: false},
+			{Name: "input_format", Required: false},
+			{Name: "output_format", Required: false},
+			{Name: "null_input", Required: false},
+		},
+		Blocks: []hcl.BlockHeaderSchema{
+			{Type: "csv"},
+			{Type: "json"},
+			{Type: "strict_json"},
+		},
+	}
+
+	bodyContent, bodyDiags := block.Body.Content(bodySchema)
+	diags = diags.Extend(bodyDiags)
+	if bodyDiags.HasErrors() {
+		return nil, diags
+	}
+
+	// Parse params as a list of bare identifiers
+	var params []string
+	if paramsAttr := bodyContent.Attributes["params"]; paramsAttr != nil {
+		// Parse the params expression as a tuple of identifiers
+		parsedParams, paramDiags := parseParamsList(paramsAttr.Expr)
+		diags = diags.Extend(paramDiags)
+		if paramDiags.HasErrors() {
+			return nil, diags
+		}
+		params = parsedParams
+	}
+
+	// Get query as a string
+	var query string
+	if queryAttr := bodyContent.Attributes["query"]; queryAttr != nil {
+		// Query should be a string
+		queryVal, queryDiags := queryAttr.Expr.Value(cfg.evalContext)
+		diags = diags.Extend(queryDiags)
+		if queryDiags.HasErrors() {
+			return nil, diags
+		}
+		if queryVal.Type() != cty.String || queryVal.IsNull() || !queryVal.IsKnown() {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid query type",
+				Detail:   "Query must be a string literal",
+				Subject:  queryAttr.Expr.Range().Ptr(),
+			})
+			return nil, diags
+		}
+		query = queryVal.AsString()
+	}
+
+	// Validate that query is not empty
+	if query == "" {
+		diags = diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Missing query",
+			Detail:   fmt.Sprintf("%s blocks must specify a 'query' attribute", blockType),
+			Subject:  &block.DefRange,
+		})
+		return nil, diags
+	}
+
+	funcDef := cfg.functionDef(block.Labels[0], query, params, block.DefRange)
+
+	// Get the optional flags
+	attrs := bodyContent.Attributes
+	diags = diags.Extend(decodeBoolAttribute(attrs["sensitive"], cfg.evalContext, &funcDef.Sensitive))
+	diags = diags.Extend(decodeBoolAttribute(attrs["infer_collections"], cfg.evalContext, &funcDef.InferCollections))
+	diags = diags.Extend(decodeBoolAttribute(attrs["null_input"], cfg.evalContext, &funcDef.NullInput))
+
+	// Get the optional declared return type
+	if typeAttr := attrs["return_type"]; typeAttr != nil {
+		ty, typeDiags := cfg.registry().typeConstraint(typeAttr.Expr)
+		diags = diags.Extend(typeDiags)
+		funcDef.ReturnType = ty
+	}
+
+	// Get the optional result mode
+	var results string
+	if stringDiags := decodeStringAttribute(attrs["results"], cfg.evalContext, &results); stringDiags.HasErrors() {
+		diags = diags.Extend(stringDiags)
+	} else if results != "" {
+		funcDef.Results = ResultMode(results)
+		if !funcDef.Results.valid() {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid results value",
+				Detail:   fmt.Sprintf("results must be one of %q, %q or %q", ResultsAuto, ResultsArray, ResultsFirst),
+				Subject:  attrs["results"].Expr.Range().Ptr(),
+			})
+		}
+	}
+
+	// Get the optional timeout
+	var timeout string
+	if stringDiags := decodeStringAttribute(attrs["timeout"], cfg.evalContext, &timeout); stringDiags.HasErrors() {
+		diags = diags.Extend(stringDiags)
+	} else if timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d <= 0 {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid timeout value",
+				Detail:   fmt.Sprintf("timeout must be a positive duration such as \"500ms\" or \"2s\", got %q", timeout),
+				Subject:  attrs["timeout"].Expr.Range().Ptr(),
+			})
+		}
+		funcDef.Timeout = d
+	}
+
+	// Get the optional input and output formats
+	diags = diags.Extend(decodeFormatAttribute(attrs["input_format"], cfg.evalContext, inputFormats, &funcDef.InputFormat))
+	diags = diags.Extend(decodeFormatAttribute(attrs["output_format"], cfg.evalContext, outputFormats, &funcDef.OutputFormat))
+
+	// Get the optional csv options
+	for i, csvBlock := range bodyContent.Blocks.OfType("csv") {
+		if i > 0 {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Duplicate csv block",
+				Detail:   fmt.Sprintf("%s blocks may have only one csv block", blockType),
+				Subject:  &csvBlock.DefRange,
+			})
+			continue
+		}
+		diags = diags.Extend(decodeCSVBlock(csvBlock, cfg.evalContext, &funcDef.CSV))
+	}
+
+	// Get the optional json options
+	for i, jsonBlock := range bodyContent.Blocks.OfType("json") {
+		if i > 0 {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Duplicate json block",
+				Detail:   fmt.Sprintf("%s blocks may have only one json block", blockType),
+				Subject:  &jsonBlock.DefRange,
+			})
+			continue
+		}
+		diags = diags.Extend(decodeJSONBlock(jsonBlock, cfg.evalContext, &funcDef.JSON))
+	}
+
+	// Get the optional strict_json options
+	for i, strictBlock := range bodyContent.Blocks.OfType("strict_json") {
+		if i > 0 {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Duplicate strict_json block",
+				Detail:   fmt.Sprintf("%s blocks may have only one strict_json block", blockType),
+				Subject:  &strictBlock.DefRange,
+			})
+			continue
+		}
+		funcDef.StrictJSON = &StrictJSONOptions{}
+		diags = diags.Extend(decodeStrictJSONBlock(strictBlock, cfg.evalContext, funcDef.StrictJSON))
+	}
+
+	return funcDef, diags
+}
+
+// decodeCSVBlock decodes the options of a csv block into target
+func decodeCSVBlock(block *hcl.Block, ctx *hcl.EvalContext, target *CSVOptions) hcl.Diagnostics {
+	content, diags := block.Body.Content(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{
+			{Name: "header", Required: false},
+			{Name: "delimiter", Required: false},
+			{Name: "infer_types", Required: false},
+		},
+	})
+	if diags.HasErrors() {
+		return diags
+	}
+	attrs := content.Attributes
+
+	header := !target.NoHeader
+	diags = diags.Extend(decodeBoolAttribute(attrs["header"], ctx, &header))
+	target.NoHeader = !header
+	diags = diags.Extend(decodeBoolAttribute(attrs["infer_types"], ctx, &target.InferTypes))
+
+	var delimiter string
+	if stringDiags := decodeStringAttribute(attrs["delimiter"], ctx, &delimiter); stringDiags.HasErrors() {
+		diags = diags.Extend(stringDiags)
+	} else if attrs["delimiter"] != nil {
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || !validDelimiter(r) {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid delimiter value",
+				Detail:   "delimiter must be a single character other than a quote or a line break",
+				Subject:  attrs["delimiter"].Expr.Range().Ptr(),
+			})
+		}
+		target.Delimiter = r
+	}
+	return diags
+}
+
+// decodeJSONBlock decodes the options of a json block into target
+func decodeJSONBlock(block *hcl.Block, ctx *hcl.EvalContext, target *JSONOptions) hcl.Diagnostics {
+	content, diags := block.Body.Content(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{
+			{Name: "jq_compatible", Required: false},
+			{Name: "indent", Required: false},
+			{Name: "ascii", Required: false},
+			{Name: "preserve_order", Required: false},
+		},
+	})
+	if diags.HasErrors() {
+		return diags
+	}
+	attrs := content.Attributes
+
+	diags = diags.Extend(decodeBoolAttribute(attrs["jq_compatible"], ctx, &target.JqCompatible))
+	diags = diags.Extend(decodeBoolAttribute(attrs["ascii"], ctx, &target.ASCII))
+	diags = diags.Extend(decodeBoolAttribute(attrs["preserve_order"], ctx, &target.PreserveOrder))
+	diags = diags.Extend(decodeIntAttribute(attrs["indent"], ctx, "spaces", 0, maxJSONIndent, &target.Indent))
+	return diags
+}
+
+// decodeStrictJSONBlock decodes the options of a strict_json block into
+// target
+func decodeStrictJSONBlock(block *hcl.Block, ctx *hcl.EvalContext, target *StrictJSONOptions) hcl.Diagnostics {
+	content, diags := block.Body.Content(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{
+			{Name: "max_size", Required: false},
+			{Name: "max_depth", Required: false},
+		},
+	})
+	if diags.HasErrors() {
+		return diags
+	}
+	attrs := content.Attributes
+
+	diags = diags.Extend(decodeIntAttribute(attrs["max_size"], ctx, "", 0, math.MaxInt, &target.MaxSize))
+	diags = diags.Extend(decodeIntAttribute(attrs["max_depth"], ctx, "", 0, maxJSONDepth, &target.MaxDepth))
+	return diags
+}
+
+// decodeIntAttribute evaluates an optional attribute that must be a whole
+// number from least to most, storing it in target when the attribute is
+// present. The unit, when not empty, names what the number counts in the
+// error message.
+func decodeIntAttribute(attr *hcl.Attribute, ctx *hcl.EvalContext, unit string, least, most int, target *int) hcl.Diagnostics {
+	if attr == nil {
+		return nil
+	}
+
+	val, diags := attr.Expr.Value(ctx)
+	if diags.HasErrors() {
+		return diags
+	}
+	var n int
+	if val.Type() != cty.Number || val.IsNull() || !val.IsKnown() || gocty.FromCtyValue(val, &n) != nil || n < least || n > most {
+		number := "a whole number"
+		if unit != "" {
+			number += " of " + unit
+		}
+		detail := fmt.Sprintf("%s must be %s from %d to %d", attr.Name, number, least, most)
+		if most == math.MaxInt {
+			detail = fmt.Sprintf("%s must be %s of at least %d", attr.Name, number, least)
+		}
+		return diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Invalid %s value", attr.Name),
+			Detail:   detail,
+			Subject:  attr.Expr.Range().Ptr(),
+		})
+	}
+	*target = n
+	return diags
+}
+
+// decodeFormatAttribute evaluates an optional attribute naming one of the
+// given formats, storing it in target when the attribute is present
+func decodeFormatAttribute(attr *hcl.Attribute, ctx *hcl.EvalContext, formats []Format, target *Format) hcl.Diagnostics {
+	var format string
+	diags := decodeStringAttribute(attr, ctx, &format)
+	if diags.HasErrors() || attr == nil {
+		return diags
+	}
+	if !containsFormat(formats, Format(format)) {
+		return diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Invalid %s value", attr.Name),
+			Detail:   fmt.Sprintf("%s must be one of %s", attr.Name, formatList(formats)),
+			Subject:  attr.Expr.Range().Ptr(),
+		})
+	}
+	*target = Format(format)
+	return diags
+}
+
+// decodeBoolAttribute evaluates an optional attribute that must be a boolean,
+// storing it in target when the attribute is present
+func decodeBoolAttribute(attr *hcl.Attribute, ctx *hcl.EvalContext, target *bool) hcl.Diagnostics {
+	if attr == nil {
+		return nil
+	}
+
+	val, diags := attr.Expr.Value(ctx)
+	if diags.HasErrors() {
+		return diags
+	}
+	if val.Type() != cty.Bool || val.IsNull() || !val.IsKnown() {
+		return diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Invalid %s value", attr.Name),
+			Detail:   fmt.Sprintf("%s must be a boolean literal (true or false)", attr.Name),
+			Subject:  attr.Expr.Range().Ptr(),
+		})
+	}
+	*target = val.True()
+	return diags
+}
+
+// decodeStringAttribute evaluates an optional attribute that must be a
+// string, storing it in target when the attribute is present
+func decodeStringAttribute(attr *hcl.Attribute, ctx *hcl.EvalContext, target *string) hcl.Diagnostics {
+	if attr == nil {
+		return nil
+	}
+
+	val, diags := attr.Expr.Value(ctx)
+	if diags.HasErrors() {
+		return diags
+	}
+	if val.Type() != cty.String || val.IsNull() || !val.IsKnown() {
+		return diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Invalid %s value", attr.Name),
+			Detail:   fmt.Sprintf("%s must be a string", attr.Name),
+			Subject:  attr.Expr.Range().Ptr(),
+		})
+	}
+	*target = val.AsString()
+	return diags
+}
+
+// parseParamsList parses a params expression as a tuple/list of bare identifiers
+func parseParamsList(expr hcl.Expression) ([]string, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+
+	// Try to parse as a tuple expression (list of identifiers)
+	if tupleExpr, ok := expr.(*hclsyntax.TupleConsExpr); ok {
+		var params []string
+		for _, elemExpr := range tupleExpr.Exprs {
+			// Each element should be a variable expression (bare identifier)
+			if varExpr, ok := elemExpr.(*hclsyntax.ScopeTraversalExpr); ok {
+				// Check that it's a simple identifier (no dots)
+				if len(varExpr.Traversal) == 1 {
+					if step, ok := varExpr.Traversal[0].(hcl.TraverseRoot); ok {
+						params = append(params, step.Name)
+						continue
+					}
+				}
+			}
+
+			// If we get here, the element is not a simple identifier
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid parameter",
+				Detail:   "Parameters must be bare identifiers (e.g., [a, b, c])",
+				Subject:  elemExpr.Range().Ptr(),
+			})
+		}
+		return params, diags
+	}
+
+	// If it's not a tuple, it might be an empty list or invalid syntax
+	diags = diags.Append(&hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  "Invalid params syntax",
+		Detail:   "params must be a list of bare identifiers, e.g., params = [a, b, c]",
+		Subject:  expr.Range().Ptr(),
+	})
+
+	return nil, diags
+}
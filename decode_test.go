@@ -0,0 +1,268 @@
+package jqfunc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/itchyny/gojq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func parseTestBody(t testing.TB, hclCode string) hcl.Body {
+	t.Helper()
+	parser := hclparse.NewParser()
+	file, diags := parser.ParseHCL([]byte(hclCode), "decode.hcl")
+	require.False(t, diags.HasErrors(), "HCL parsing should succeed: %s", diags)
+	return file.Body
+}
+
+func TestDecodeJqFunctionsWithOptions(t *testing.T) {
+	t.Run("default block type", func(t *testing.T) {
+		body := parseTestBody(t, `
+jq "double" {
+    query = ". * 2"
+}
+`)
+		functions, _, diags := DecodeJqFunctionsWithOptions(body)
+		require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+
+		result, err := functions["double"].Call([]cty.Value{cty.NumberIntVal(21)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.RawEquals(cty.NumberIntVal(42)), "Got %#v", result)
+	})
+
+	t.Run("eval context", func(t *testing.T) {
+		body := parseTestBody(t, `
+jq "field" {
+    query = ".${field}"
+    sensitive = secret
+}
+`)
+		ctx := &hcl.EvalContext{
+			Variables: map[string]cty.Value{
+				"field":  cty.StringVal("name"),
+				"secret": cty.True,
+			},
+		}
+		functions, _, diags := DecodeJqFunctionsWithOptions(body, WithEvalContext(ctx))
+		require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+
+		result, err := functions["field"].Call([]cty.Value{cty.StringVal(`{"name": "x"}`)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.HasMark(SensitiveMark))
+		unmarked, _ := result.Unmark()
+		assert.Equal(t, "x", unmarked.AsString())
+	})
+
+	t.Run("compiler options", func(t *testing.T) {
+		body := parseTestBody(t, `
+jq "shout" {
+    query = "shout"
+}
+`)
+		upper := gojq.WithFunction("shout", 0, 0, func(v interface{}, _ []interface{}) interface{} {
+			return v.(string) + "!"
+		})
+		functions, _, diags := DecodeJqFunctionsWithOptions(body, WithCompilerOptions(upper))
+		require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+
+		result, err := functions["shout"].Call([]cty.Value{cty.StringVal(`"hey"`)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, "hey!", result.AsString())
+
+		_, _, diags = DecodeJqFunctionsWithOptions(body)
+		require.True(t, diags.HasErrors(), "Unknown function should fail to compile")
+	})
+
+	t.Run("defaults and block overrides", func(t *testing.T) {
+		body := parseTestBody(t, `
+jq "default" {
+    query = ".[]"
+}
+
+jq "auto" {
+    query = ".[]"
+    results = "auto"
+}
+`)
+		functions, _, diags := DecodeJqFunctionsWithOptions(body, WithResults(ResultsFirst))
+		require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+
+		input := cty.StringVal(`[1, 2]`)
+		result, err := functions["default"].Call([]cty.Value{input})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, "1", result.AsString())
+
+		result, err = functions["auto"].Call([]cty.Value{input})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, "[1,2]", result.AsString())
+	})
+
+	t.Run("duplicate policies", func(t *testing.T) {
+		body := parseTestBody(t, `
+jq "f" {
+    query = "1"
+}
+
+jq "f" {
+    query = "2"
+}
+`)
+		functions, _, diags := DecodeJqFunctionsWithOptions(body)
+		assert.Empty(t, diags)
+		result, err := functions["f"].Call([]cty.Value{cty.StringVal(`null`)})
+		require.NoError(t, err)
+		assert.Equal(t, "2", result.AsString())
+
+		functions, _, diags = DecodeJqFunctionsWithOptions(body, WithDuplicates(DuplicatesWarn))
+		require.Len(t, diags, 1)
+		assert.Equal(t, hcl.DiagWarning, diags[0].Severity)
+		assert.Equal(t, "Duplicate jq function", diags[0].Summary)
+		result, err = functions["f"].Call([]cty.Value{cty.StringVal(`null`)})
+		require.NoError(t, err)
+		assert.Equal(t, "2", result.AsString())
+
+		functions, _, diags = DecodeJqFunctionsWithOptions(body, WithDuplicates(DuplicatesError))
+		require.True(t, diags.HasErrors())
+		result, err = functions["f"].Call([]cty.Value{cty.StringVal(`null`)})
+		require.NoError(t, err)
+		assert.Equal(t, "1", result.AsString())
+	})
+
+	t.Run("partial results", func(t *testing.T) {
+		body := parseTestBody(t, `
+jq "good" {
+    query = "."
+}
+
+jq "bad" {
+    query = ".["
+}
+`)
+		functions, _, diags := DecodeJqFunctionsWithOptions(body)
+		require.True(t, diags.HasErrors())
+		assert.Contains(t, functions, "good")
+
+		functions, _, diags = DecodeJqFunctionsWithOptions(body, WithPartialResults(false))
+		require.True(t, diags.HasErrors())
+		assert.Nil(t, functions)
+	})
+
+	t.Run("wrapper keeps block type", func(t *testing.T) {
+		body := parseTestBody(t, `
+jqfunction "f" {
+    query = "."
+}
+
+jq "g" {
+    query = "."
+}
+`)
+		functions, remaining, diags := DecodeJqFunctions(body, "jqfunction")
+		require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+		assert.Contains(t, functions, "f")
+		assert.NotContains(t, functions, "g")
+
+		functions, _, diags = DecodeJqFunctionsWithOptions(remaining)
+		require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+		assert.Contains(t, functions, "g")
+	})
+}
+
+func TestResultModes(t *testing.T) {
+	body := parseTestBody(t, `
+jq "all" {
+    params = []
+    query = ".[]"
+    results = "array"
+}
+
+jq "first" {
+    params = []
+    query = ".[]"
+    results = "first"
+}
+
+jq "first_of_infinite" {
+    params = []
+    query = "repeat(1)"
+    results = "first"
+}
+`)
+	functions, _, diags := DecodeJqFunctionsWithOptions(body)
+	require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+
+	tests := []struct {
+		name     string
+		function string
+		input    string
+		expected string
+	}{
+		{"array of several", "all", `[1, 2]`, `[1,2]`},
+		{"array of one", "all", `[1]`, `[1]`},
+		{"array of none", "all", `[]`, `[]`},
+		{"first of several", "first", `[1, 2]`, `1`},
+		{"first of none", "first", `[]`, `null`},
+		{"first stops the query", "first_of_infinite", `null`, `1`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := functions[tt.function].Call([]cty.Value{cty.StringVal(tt.input)})
+			require.NoError(t, err, "Function call should succeed")
+			assert.Equal(t, tt.expected, result.AsString())
+		})
+	}
+
+	t.Run("array of one from cty input", func(t *testing.T) {
+		input := cty.ListVal([]cty.Value{cty.StringVal("a")})
+		result, err := functions["all"].Call([]cty.Value{input})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.RawEquals(cty.ListVal([]cty.Value{cty.StringVal("a")})), "Got %#v", result)
+	})
+
+	t.Run("invalid mode", func(t *testing.T) {
+		body := parseTestBody(t, `
+jq "f" {
+    query = "."
+    results = "last"
+}
+`)
+		_, _, diags := DecodeJqFunctionsWithOptions(body)
+		require.True(t, diags.HasErrors())
+		assert.Equal(t, "Invalid results value", diags[0].Summary)
+	})
+}
+
+func TestTimeout(t *testing.T) {
+	body := parseTestBody(t, `
+jq "forever" {
+    query = "last(repeat(1))"
+    timeout = "50ms"
+}
+`)
+	functions, _, diags := DecodeJqFunctionsWithOptions(body)
+	require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+
+	start := time.Now()
+	_, err := functions["forever"].Call([]cty.Value{cty.StringVal(`null`)})
+	require.Error(t, err, "Call should time out")
+	assert.Contains(t, err.Error(), "timed out after 50ms")
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	t.Run("invalid timeout", func(t *testing.T) {
+		body := parseTestBody(t, `
+jq "f" {
+    query = "."
+    timeout = "soon"
+}
+`)
+		_, _, diags := DecodeJqFunctionsWithOptions(body)
+		require.True(t, diags.HasErrors())
+		assert.Equal(t, "Invalid timeout value", diags[0].Summary)
+	})
+}
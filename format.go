@@ -0,0 +1,171 @@
+package jqfunc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Format names a text format for string input or output
+type Format string
+
+const (
+	// FormatJSON is a single JSON document, the default input format
+	FormatJSON Format = "json"
+
+	// FormatJSONC is a single JSON document that may contain comments and
+	// trailing commas
+	FormatJSONC Format = "jsonc"
+
+	// FormatJSON5 is a single JSON5 document
+	FormatJSON5 Format = "json5"
+
+	// FormatYAML is a stream of YAML documents, each one a separate input
+	FormatYAML Format = "yaml"
+
+	// FormatNDJSON is a stream of JSON documents, one per line, which the
+	// query reads with input and inputs as well as through its input. JSON
+	// text sequences (RFC 7464) are accepted too. As an output format it
+	// writes each result on a line of its own.
+	FormatNDJSON Format = "ndjson"
+
+	// FormatCSV is comma-separated values, read as an array of rows as
+	// configured by CSVOptions
+	FormatCSV Format = "csv"
+
+	// FormatTSV is tab-separated values, read like FormatCSV
+	FormatTSV Format = "tsv"
+
+	// FormatTOML is a TOML document
+	FormatTOML Format = "toml"
+
+	// FormatHCL is a string of HCL attributes, evaluated without variables
+	// or functions into an object
+	FormatHCL Format = "hcl"
+)
+
+// inputFormats and outputFormats list the formats accepted for
+// input_format and output_format
+var (
+	inputFormats  = []Format{FormatJSON, FormatYAML, FormatNDJSON, FormatCSV, FormatTSV, FormatTOML, FormatHCL, FormatJSONC, FormatJSON5}
+	outputFormats = []Format{FormatJSON, FormatYAML, FormatNDJSON}
+)
+
+// validInput reports whether the format can be used for input; empty means
+// the default
+func (f Format) validInput() bool {
+	return f == "" || containsFormat(inputFormats, f)
+}
+
+// validOutput reports whether the format can be used for output; empty
+// means the default
+func (f Format) validOutput() bool {
+	return f == "" || containsFormat(outputFormats, f)
+}
+
+// isJSON reports whether input in the format is JSON or a dialect of it,
+// which can be parsed strictly and whose key order can be preserved
+func (f Format) isJSON() bool {
+	return f == "" || f == FormatJSON || f == FormatJSONC || f == FormatJSON5 || f == FormatNDJSON
+}
+
+// feedsInputs reports whether the documents of the format are available to
+// the query through input and inputs
+func (f Format) feedsInputs() bool {
+	return f == FormatNDJSON
+}
+
+// displayName returns the name used for the format in error messages
+func (f Format) displayName() string {
+	if f == "" {
+		f = FormatJSON
+	}
+	return strings.ToUpper(string(f))
+}
+
+func containsFormat(formats []Format, f Format) bool {
+	for _, format := range formats {
+		if format == f {
+			return true
+		}
+	}
+	return false
+}
+
+// formatList lists formats for error messages, e.g. "json" or "yaml"
+func formatList(formats []Format) string {
+	quoted := make([]string, len(formats))
+	for i, format := range formats {
+		quoted[i] = fmt.Sprintf("%q", format)
+	}
+	if len(quoted) == 1 {
+		return quoted[0]
+	}
+	return strings.Join(quoted[:len(quoted)-1], ", ") + " or " + quoted[len(quoted)-1]
+}
+
+// decodeInput parses string input in the function's input format into its
+// documents, recording the key order and decimals of JSON in the converter
+// of the call
+func (jqFunc *JqFunction) decodeInput(data []byte, c *converter) ([]interface{}, error) {
+	if jqFunc.InputFormat.isJSON() {
+		return jqFunc.decodeJSONInput(data, c)
+	}
+
+	var v interface{}
+	var err error
+	switch jqFunc.InputFormat {
+	case FormatYAML:
+		return decodeYAML(data)
+	case FormatCSV, FormatTSV:
+		v, err = decodeCSV(data, jqFunc.InputFormat, jqFunc.CSV)
+	case FormatTOML:
+		v, err = decodeTOML(data)
+	case FormatHCL:
+		v, err = c.decodeHCL(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{v}, nil
+}
+
+// decodeJSONInput parses input in JSON or one of its dialects
+func (jqFunc *JqFunction) decodeJSONInput(data []byte, c *converter) ([]interface{}, error) {
+	dialect := dialectJSON
+	switch jqFunc.InputFormat {
+	case FormatJSONC:
+		dialect = dialectJSONC
+	case FormatJSON5:
+		dialect = dialectJSON5
+	}
+	d, err := jqFunc.newJSONDecoder(data, dialect, c)
+	if err != nil {
+		return nil, err
+	}
+	if jqFunc.InputFormat == FormatNDJSON {
+		return d.stream()
+	}
+	v, err := d.document()
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{v}, nil
+}
+
+// encodeOutput encodes a result in the given output format, writing JSON as
+// configured by opts
+func encodeOutput(format Format, v interface{}, opts JSONOptions) ([]byte, error) {
+	switch format {
+	case FormatYAML:
+		return encodeYAML(v)
+	case FormatNDJSON:
+		opts.Indent = 0
+		line, err := opts.encode(v)
+		if err != nil {
+			return nil, err
+		}
+		return append(line, '\n'), nil
+	default:
+		return opts.encode(v)
+	}
+}
@@ -0,0 +1,102 @@
+package jqfunc
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/userfunc"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// DecodeFunctions decodes HCL user functions (from "function" blocks, as
+// userfunc.DecodeUserFunctions does) together with jq functions, returning a
+// single function table and one set of diagnostics.
+//
+// The result expressions of user functions are evaluated in a child of the
+// EvalContext given with WithEvalContext that also holds every decoded
+// function, so user functions can call jq functions and each other. The
+// attributes of jq blocks are evaluated in the same context, so they may
+// call user functions, but not, even indirectly, other jq functions, which
+// are only defined once decoding is complete.
+//
+// A name defined by both kinds of block is an error; the block that comes
+// first is kept. Options are as for DecodeJqFunctionsWithOptions, plus
+// WithUserFunctionBlockType.
+func DecodeFunctions(body hcl.Body, opts ...Option) (map[string]function.Function, hcl.Body, hcl.Diagnostics) {
+	cfg := newConfig(opts)
+
+	// Every function, of either kind, sees the complete function table
+	var ctx *hcl.EvalContext
+	if cfg.evalContext != nil {
+		ctx = cfg.evalContext.NewChild()
+	} else {
+		ctx = &hcl.EvalContext{}
+	}
+	ctx.Functions = make(map[string]function.Function)
+
+	// Find where each name is first defined, to resolve collisions
+	schema := &hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{
+			{Type: cfg.userFuncBlockType, LabelNames: []string{"name"}},
+			{Type: cfg.blockType, LabelNames: []string{"name"}},
+		},
+	}
+	content, _, diags := body.PartialContent(schema)
+	if diags.HasErrors() {
+		return nil, body, diags
+	}
+	first := make(map[string]*hcl.Block)
+	for _, block := range content.Blocks {
+		if _, exists := first[block.Labels[0]]; !exists {
+			first[block.Labels[0]] = block
+		}
+	}
+
+	// User functions are decoded first: their bodies are only evaluated when
+	// they are called, by which time the table is complete
+	userFuncs, remain, userDiags := userfunc.DecodeUserFunctions(body, cfg.userFuncBlockType, func() *hcl.EvalContext {
+		return ctx
+	})
+	diags = diags.Extend(userDiags)
+	for name, fn := range userFuncs {
+		if block := first[name]; block != nil && block.Type == cfg.userFuncBlockType {
+			ctx.Functions[name] = fn
+		}
+	}
+
+	jqCfg := *cfg
+	jqCfg.evalContext = ctx
+	jqFuncs, remain, jqDiags := decodeJqFunctions(remain, &jqCfg)
+	diags = diags.Extend(jqDiags)
+
+	for _, jqFunc := range jqFuncs {
+		if block := first[jqFunc.Name]; block != nil && block.Type == cfg.blockType {
+			ctx.Functions[jqFunc.Name] = jqFunc.Function()
+		}
+	}
+
+	// Report names defined by both kinds of block at the later definition
+	for _, block := range content.Blocks {
+		name := block.Labels[0]
+		kept := first[name]
+		if kept.Type == block.Type {
+			continue
+		}
+		diags = diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Duplicate function",
+			Detail:   fmt.Sprintf("A function named %q was already defined by a %s block at %s", name, kept.Type, kept.DefRange),
+			Subject:  &block.DefRange,
+		})
+	}
+
+	if !cfg.partialResults && diags.HasErrors() {
+		return nil, remain, diags
+	}
+
+	functions := make(map[string]function.Function, len(ctx.Functions))
+	for name, fn := range ctx.Functions {
+		functions[name] = fn
+	}
+	return functions, remain, diags
+}
@@ -0,0 +1,165 @@
+package jqfunc
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+)
+
+func TestDecodeFunctions(t *testing.T) {
+	t.Run("user functions call jq functions", func(t *testing.T) {
+		body := parseTestBody(t, `
+function "shout_names" {
+    params = [doc]
+    result = upper(join(", ", names(doc)))
+}
+
+jq "names" {
+    query = "[.[].name]"
+}
+
+function "count" {
+    params = [doc]
+    result = length(names(doc))
+}
+`)
+		ctx := &hcl.EvalContext{
+			Functions: map[string]function.Function{
+				"upper":  stdlib.UpperFunc,
+				"join":   stdlib.JoinFunc,
+				"length": stdlib.LengthFunc,
+			},
+		}
+		functions, remain, diags := DecodeFunctions(body, WithEvalContext(ctx))
+		require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+		assert.Len(t, functions, 3)
+
+		input := cty.TupleVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("a")}),
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("b")}),
+		})
+		result, err := functions["shout_names"].Call([]cty.Value{input})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, "A, B", result.AsString())
+
+		result, err = functions["count"].Call([]cty.Value{input})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.RawEquals(cty.NumberIntVal(2)), "Got %#v", result)
+
+		// The function blocks have been consumed
+		_, diags = remain.Content(&hcl.BodySchema{})
+		assert.False(t, diags.HasErrors(), "Remaining body should have no blocks: %s", diags)
+	})
+
+	t.Run("jq blocks use user functions", func(t *testing.T) {
+		body := parseTestBody(t, `
+function "field_query" {
+    params = [name]
+    result = ".${name}"
+}
+
+jq "get_id" {
+    query = field_query("id")
+}
+`)
+		functions, _, diags := DecodeFunctions(body)
+		require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+
+		result, err := functions["get_id"].Call([]cty.Value{cty.StringVal(`{"id": 5}`)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, "5", result.AsString())
+	})
+
+	t.Run("functions in expressions", func(t *testing.T) {
+		body := parseTestBody(t, `
+jq "double" {
+    query = ". * 2"
+}
+
+function "quadruple" {
+    params = [n]
+    result = double(double(n))
+}
+`)
+		functions, _, diags := DecodeFunctions(body)
+		require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+
+		expr, parseDiags := hclsyntax.ParseExpression([]byte(`quadruple(3)`), "expr.hcl", hcl.InitialPos)
+		require.False(t, parseDiags.HasErrors())
+		result, valDiags := expr.Value(&hcl.EvalContext{Functions: functions})
+		require.False(t, valDiags.HasErrors(), "Evaluation should succeed: %s", valDiags)
+		assert.True(t, result.RawEquals(cty.NumberIntVal(12)), "Got %#v", result)
+	})
+
+	t.Run("custom block types", func(t *testing.T) {
+		body := parseTestBody(t, `
+fn "inc" {
+    params = [n]
+    result = add_one(n)
+}
+
+transform "add_one" {
+    query = ". + 1"
+}
+`)
+		functions, _, diags := DecodeFunctions(body, WithBlockType("transform"), WithUserFunctionBlockType("fn"))
+		require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+
+		result, err := functions["inc"].Call([]cty.Value{cty.NumberIntVal(1)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.RawEquals(cty.NumberIntVal(2)), "Got %#v", result)
+	})
+
+	t.Run("name defined by both kinds", func(t *testing.T) {
+		body := parseTestBody(t, `
+jq "f" {
+    query = "1"
+}
+
+function "f" {
+    params = []
+    result = 2
+}
+`)
+		functions, _, diags := DecodeFunctions(body)
+		require.Len(t, diags, 1)
+		assert.Equal(t, "Duplicate function", diags[0].Summary)
+		assert.Equal(t, 6, diags[0].Subject.Start.Line)
+
+		result, err := functions["f"].Call([]cty.Value{cty.StringVal(`null`)})
+		require.NoError(t, err, "The first definition should be kept")
+		assert.Equal(t, "1", result.AsString())
+	})
+
+	t.Run("combined diagnostics", func(t *testing.T) {
+		body := parseTestBody(t, `
+function "bad_user" {
+    params = [1]
+    result = 1
+}
+
+jq "bad_jq" {
+    query = ".["
+}
+
+jq "good" {
+    query = "."
+}
+`)
+		functions, _, diags := DecodeFunctions(body)
+		require.Len(t, diags, 2)
+		assert.Equal(t, "Invalid param element", diags[0].Summary)
+		assert.Equal(t, "Invalid jq query", diags[1].Summary)
+		assert.Contains(t, functions, "good")
+
+		functions, _, diags = DecodeFunctions(body, WithPartialResults(false))
+		assert.Len(t, diags, 2)
+		assert.Nil(t, functions)
+	})
+}
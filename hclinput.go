@@ -0,0 +1,35 @@
+package jqfunc
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// hclInputFilename names string input in the positions of HCL diagnostics
+const hclInputFilename = "<input>"
+
+// decodeHCL parses a string of HCL attributes, evaluates them without any
+// variables or functions and converts them to a jq object. Errors are the
+// HCL diagnostics, with positions inside the string.
+func (c *converter) decodeHCL(data []byte) (interface{}, error) {
+	file, diags := hclsyntax.ParseConfig(data, hclInputFilename, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	attrs, diags := file.Body.JustAttributes()
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	values := make(map[string]cty.Value, len(attrs))
+	for name, attr := range attrs {
+		val, valDiags := attr.Expr.Value(nil)
+		diags = diags.Extend(valDiags)
+		values[name] = val
+	}
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	return c.ctyToJq(cty.ObjectVal(values))
+}
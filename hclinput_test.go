@@ -0,0 +1,75 @@
+package jqfunc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestDecodeHCLInput(t *testing.T) {
+	c := &converter{}
+
+	v, err := c.decodeHCL([]byte("name = \"web\"\nport = 8080\nratio = 1.5 * 2\ntags = [\"a\", true, null]\ntls = { enabled = true }\nsum = \"n${1 + 2}\"\n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":  "web",
+		"port":  8080,
+		"ratio": 3,
+		"tags":  []interface{}{"a", true, nil},
+		"tls":   map[string]interface{}{"enabled": true},
+		"sum":   "n3",
+	}, v)
+
+	v, err = c.decodeHCL([]byte("# nothing\n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{}, v)
+
+	t.Run("errors give positions in the string", func(t *testing.T) {
+		errorTests := []struct {
+			name     string
+			hcl      string
+			expected string
+		}{
+			{"syntax", "a = 1\nb = 1 +\n", "<input>:2,8-3,1: Invalid expression;"},
+			{"variables", "a = 1\nb = var.x\n", "<input>:2,5-8: Variables not allowed;"},
+			{"other attributes", "a = 1\nb = a\n", "<input>:2,5-6: Variables not allowed;"},
+			{"functions", "a = upper(\"x\")\n", "<input>:1,5-15: Function calls not allowed;"},
+			{"blocks", "a = 1\n\nblk {\n}\n", "<input>:3,1-4: Unexpected \"blk\" block;"},
+			{"evaluation", "a = \"x\" + 1\n", "<input>:1,5-8: Invalid operand;"},
+		}
+		for _, tt := range errorTests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := c.decodeHCL([]byte(tt.hcl))
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expected)
+			})
+		}
+	})
+}
+
+func TestHCLFormat(t *testing.T) {
+	body := parseTestBody(t, `
+jq "keys" {
+    input_format = "hcl"
+    query        = "keys"
+}
+`)
+	functions, _, diags := DecodeJqFunctions(body, "jq")
+	require.False(t, diags.HasErrors(), "Decoding should succeed: %s", diags)
+
+	result, err := functions["keys"].Call([]cty.Value{cty.StringVal("region = \"eu\"\nsize = 3\n")})
+	require.NoError(t, err)
+	assert.Equal(t, cty.StringVal(`["region","size"]`), result)
+
+	_, err = functions["keys"].Call([]cty.Value{cty.StringVal("region = \n")})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid HCL input: <input>:1,10-2,1: Invalid expression")
+
+	fn, err := New("size", ".size", nil, WithInputFormat(FormatHCL))
+	require.NoError(t, err)
+	result, err = fn.Call([]cty.Value{cty.StringVal("size = 2 * 21")})
+	require.NoError(t, err)
+	assert.Equal(t, cty.StringVal("42"), result)
+}
@@ -0,0 +1,81 @@
+package jqfunc
+
+import (
+	"context"
+	"fmt"
+	"iter"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Iter returns an iterator over the values the query emits for input and
+// args (one per parameter), converting each to cty only when the consumer
+// reaches it. The query runs anew each time the iterator is ranged over and
+// stops as soon as the consumer breaks out of the loop; with ResultsFirst it
+// stops after the first value.
+//
+// Each value is converted as the HCL function converts a single result: for
+// JSON string input a string value is returned as is and anything else as
+// JSON, and a declared ReturnType applies to every value. Marks on the
+// arguments, and SensitiveMark for sensitive functions, are applied to every
+// value.
+//
+// An error, including cancellation of ctx and the function's Timeout, is
+// yielded once with cty.NilVal and ends the iteration. A null string input,
+// which has no document to parse, and unknown arguments are errors.
+func (jqFunc *JqFunction) Iter(ctx context.Context, input cty.Value, args ...cty.Value) iter.Seq2[cty.Value, error] {
+	return func(yield func(cty.Value, error) bool) {
+		if len(args) != len(jqFunc.Params) {
+			yield(cty.NilVal, jqFunc.executionError(fmt.Errorf("expected %d arguments after the input, got %d", len(jqFunc.Params), len(args))))
+			return
+		}
+
+		allArgs, marks := unmarkArgs(append([]cty.Value{input}, args...))
+		if allArgs[0].Type() == cty.String && allArgs[0].IsNull() {
+			yield(cty.NilVal, jqFunc.executionError(fmt.Errorf("string input must not be null")))
+			return
+		}
+		for i, arg := range allArgs {
+			if !arg.IsWhollyKnown() {
+				name := "input"
+				if i > 0 {
+					name = "parameter " + jqFunc.Params[i-1]
+				}
+				yield(cty.NilVal, jqFunc.executionError(fmt.Errorf("%s must be known", name)))
+				return
+			}
+		}
+		if jqFunc.Sensitive {
+			marks[SensitiveMark] = struct{}{}
+		}
+		converter := jqFunc.converter()
+
+		jqInputs, variableValues, isStringInput, err := jqFunc.prepareArgs(converter, allArgs)
+		if err != nil {
+			yield(cty.NilVal, err)
+			return
+		}
+
+		hint := cty.DynamicPseudoType
+		if jqFunc.ReturnType != cty.NilType {
+			hint = jqFunc.ReturnType
+		}
+
+		// Conversion errors stop the query like a consumer breaking early
+		var convErr error
+		err = jqFunc.stream(ctx, jqInputs, variableValues, func(result interface{}) bool {
+			value, err := jqFunc.resultToCty(converter, result, isStringInput, hint)
+			if err != nil {
+				convErr = err
+				return false
+			}
+			return yield(value.WithMarks(marks), nil)
+		})
+		if convErr != nil {
+			err = convErr
+		}
+		if err != nil {
+			yield(cty.NilVal, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package jqfunc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestIter(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("values from cty input", func(t *testing.T) {
+		fn, err := Compile("each", ".[] | select(.n > $min)", []string{"min"})
+		require.NoError(t, err)
+
+		input := cty.TupleVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{"n": cty.NumberIntVal(1)}),
+			cty.ObjectVal(map[string]cty.Value{"n": cty.NumberIntVal(2)}),
+			cty.ObjectVal(map[string]cty.Value{"n": cty.NumberIntVal(3)}),
+		})
+
+		var values []cty.Value
+		for value, err := range fn.Iter(ctx, input, cty.NumberIntVal(1)) {
+			require.NoError(t, err)
+			values = append(values, value)
+		}
+		// Objects whose values all have the same type become maps
+		assert.Equal(t, []cty.Value{
+			cty.MapVal(map[string]cty.Value{"n": cty.NumberIntVal(2)}),
+			cty.MapVal(map[string]cty.Value{"n": cty.NumberIntVal(3)}),
+		}, values)
+	})
+
+	t.Run("values from JSON string input", func(t *testing.T) {
+		fn, err := Compile("each", ".[]", nil)
+		require.NoError(t, err)
+
+		var values []string
+		for value, err := range fn.Iter(ctx, cty.StringVal(`["a", {"b": 1}, null]`)) {
+			require.NoError(t, err)
+			values = append(values, value.AsString())
+		}
+		assert.Equal(t, []string{"a", `{"b":1}`, "null"}, values)
+	})
+
+	t.Run("breaking stops the query", func(t *testing.T) {
+		fn, err := Compile("naturals", "range(infinite)", nil)
+		require.NoError(t, err)
+
+		var count int
+		for value, err := range fn.Iter(ctx, cty.NullVal(cty.DynamicPseudoType)) {
+			require.NoError(t, err)
+			assert.True(t, value.RawEquals(cty.NumberIntVal(int64(count))), "Got %#v", value)
+			if count++; count == 1000 {
+				break
+			}
+		}
+		assert.Equal(t, 1000, count)
+	})
+
+	t.Run("iterator can be reused", func(t *testing.T) {
+		fn, err := Compile("each", ".[]", nil)
+		require.NoError(t, err)
+
+		seq := fn.Iter(ctx, cty.StringVal(`[1, 2]`))
+		for i := 0; i < 2; i++ {
+			var count int
+			for _, err := range seq {
+				require.NoError(t, err)
+				count++
+			}
+			assert.Equal(t, 2, count)
+		}
+	})
+
+	t.Run("marks and return type apply to each value", func(t *testing.T) {
+		fn, err := Compile("tags", ".[]", nil, WithSensitive(true), WithReturnType(cty.List(cty.String)))
+		require.NoError(t, err)
+
+		input := cty.TupleVal([]cty.Value{
+			cty.EmptyTupleVal,
+			cty.TupleVal([]cty.Value{cty.StringVal("a").Mark("private")}),
+		})
+		var values []cty.Value
+		for value, err := range fn.Iter(ctx, input) {
+			require.NoError(t, err)
+			values = append(values, value)
+		}
+
+		marks := cty.NewValueMarks("private", SensitiveMark)
+		assert.Equal(t, []cty.Value{
+			cty.ListValEmpty(cty.String).WithMarks(marks),
+			cty.ListVal([]cty.Value{cty.StringVal("a")}).WithMarks(marks),
+		}, values)
+	})
+
+	t.Run("first result only", func(t *testing.T) {
+		fn, err := Compile("first", "repeat(1)", nil, WithResults(ResultsFirst))
+		require.NoError(t, err)
+
+		var count int
+		for _, err := range fn.Iter(ctx, cty.StringVal(`null`)) {
+			require.NoError(t, err)
+			count++
+		}
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("errors end the iteration", func(t *testing.T) {
+		fn, err := Compile("fails", `.[] | if . == 2 then error("two") else . end`, nil)
+		require.NoError(t, err)
+
+		var values []string
+		var errs []error
+		for value, err := range fn.Iter(ctx, cty.StringVal(`[1, 2, 3]`)) {
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			values = append(values, value.AsString())
+		}
+		assert.Equal(t, []string{"1"}, values)
+		require.Len(t, errs, 1)
+		var jqErr *JqExecutionError
+		assert.ErrorAs(t, errs[0], &jqErr)
+		assert.Contains(t, errs[0].Error(), "two")
+	})
+
+	t.Run("conversion errors end the iteration", func(t *testing.T) {
+		fn, err := Compile("numbers", ".[]", nil, WithReturnType(cty.Number))
+		require.NoError(t, err)
+
+		var count int
+		var lastErr error
+		for _, err := range fn.Iter(ctx, cty.StringVal(`[1, "x", 3]`)) {
+			count++
+			lastErr = err
+		}
+		assert.Equal(t, 2, count)
+		require.Error(t, lastErr)
+		assert.Contains(t, lastErr.Error(), "declared return type number")
+	})
+
+	t.Run("invalid arguments", func(t *testing.T) {
+		fn, err := Compile("pick", ".[$key]", []string{"key"})
+		require.NoError(t, err)
+
+		for _, err := range fn.Iter(ctx, cty.StringVal(`{}`)) {
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "expected 1 arguments after the input, got 0")
+		}
+		for _, err := range fn.Iter(ctx, cty.StringVal(`{`), cty.StringVal("k")) {
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid JSON input")
+		}
+		for _, err := range fn.Iter(ctx, cty.NullVal(cty.String), cty.StringVal("k")) {
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "string input must not be null")
+		}
+		for _, err := range fn.Iter(ctx, cty.UnknownVal(cty.String), cty.StringVal("k")) {
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "input must be known")
+		}
+		for _, err := range fn.Iter(ctx, cty.EmptyObjectVal, cty.UnknownVal(cty.String)) {
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "parameter key must be known")
+		}
+	})
+
+	t.Run("context cancellation", func(t *testing.T) {
+		fn, err := Compile("naturals", "range(infinite)", nil)
+		require.NoError(t, err)
+
+		cancelCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+
+		var lastErr error
+		for _, err := range fn.Iter(cancelCtx, cty.StringVal(`null`)) {
+			lastErr = err
+		}
+		assert.True(t, errors.Is(lastErr, context.DeadlineExceeded), "Got %v", lastErr)
+	})
+}
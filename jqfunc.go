@@ -2,14 +2,15 @@ package jqfunc
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/itchyny/gojq"
-	"github.com/tsarna/go2cty2go"
 	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
 	"github.com/zclconf/go-cty/cty/function"
 )
 
@@ -22,6 +23,10 @@ type JqExecutionError struct {
 }
 
 func (e *JqExecutionError) Error() string {
+	if e.Range.Filename == "" {
+		// Functions built with New have no source location unless given one
+		return fmt.Sprintf("jq function %s: %v", e.FunctionName, e.Cause)
+	}
 	return fmt.Sprintf("jq function %s at %s: %v", e.FunctionName, e.Range, e.Cause)
 }
 
@@ -29,388 +34,496 @@ func (e *JqExecutionError) Unwrap() error {
 	return e.Cause
 }
 
-// JqFunction represents a compiled jq function ready for execution
+// SensitiveMark is the cty mark applied to results of functions declared with
+// sensitive = true. Hosts that use their own mark value (e.g. Terraform's
+// marks.Sensitive) may replace it before decoding.
+var SensitiveMark interface{} = "sensitive"
+
+// JqFunction represents a compiled jq function ready for execution.
+//
+// A JqFunction and the HCL functions built from it are safe for concurrent
+// use by multiple goroutines. Calls share only the compiled query, which is
+// never modified after compilation, and for input formats that feed inputs a
+// pool of compilations that each serve one call at a time; every call
+// converts its own copy of the input and arguments and collects its own
+// results. The fields must not be changed once the function may be called.
 type JqFunction struct {
 	Name          string
 	Params        []string
 	Query         string
 	CompiledQuery *gojq.Code
+	Sensitive     bool      // Mark every result with SensitiveMark
 	Range         hcl.Range // For error reporting
-}
 
-// DecodeJqFunctions extracts and compiles jq function blocks from HCL bodies, returning HCL functions
-// Similar to userfunc.DecodeUserFunctions but for jq functions
-func DecodeJqFunctions(body hcl.Body, blockType string) (map[string]function.Function, hcl.Body, hcl.Diagnostics) {
-	var diags hcl.Diagnostics
+	// InferCollections makes array and object results whose element types
+	// unify become lists and maps instead of tuples and objects
+	InferCollections bool
 
-	// Define the schema for the specified block type
-	schema := &hcl.BodySchema{
-		Blocks: []hcl.BlockHeaderSchema{
-			{
-				Type:       blockType,
-				LabelNames: []string{"name"},
-			},
-		},
-	}
+	// ReturnType is the declared result type, or cty.NilType when the
+	// function was declared without one
+	ReturnType cty.Type
 
-	// Extract jqfunction blocks
-	content, remainingBody, contentDiags := body.PartialContent(schema)
-	diags = diags.Extend(contentDiags)
-	if diags.HasErrors() {
-		return nil, nil, diags
-	}
+	// Results selects how the values the query emits become the result
+	Results ResultMode
 
-	hclFunctions := make(map[string]function.Function)
+	// Timeout limits how long a single call may run; zero means no limit
+	Timeout time.Duration
 
-	// Process each block of the specified type
-	for _, block := range content.Blocks {
-		if block.Type != blockType {
-			continue
-		}
+	// Converters converts capsule-typed values; DefaultConverters is used
+	// when it is nil
+	Converters *ConverterRegistry
 
-		// Ensure we have exactly one label (the function name)
-		if len(block.Labels) != 1 {
-			diags = diags.Append(&hcl.Diagnostic{
-				Severity: hcl.DiagError,
-				Summary:  fmt.Sprintf("Invalid %s block", blockType),
-				Detail:   fmt.Sprintf("%s blocks must have exactly one label (the function name)", blockType),
-				Subject:  &block.DefRange,
-			})
-			continue
-		}
+	// InputFormat is the format of string input; JSON when empty
+	InputFormat Format
 
-		// Define schema for the block body to get params and query
-		bodySchema := &hcl.BodySchema{
-			Attributes: []hcl.AttributeSchema{
-				{Name: "params", Required: false},
-				{Name: "query", Required: true},
-			},
-		}
+	// OutputFormat, when not empty, makes every result a string in this
+	// format instead of a cty value or JSON
+	OutputFormat Format
 
-		bodyContent, bodyDiags := block.Body.Content(bodySchema)
-		diags = diags.Extend(bodyDiags)
-		if bodyDiags.HasErrors() {
-			continue
-		}
+	// NullInput runs the query once with null as its input, leaving every
+	// document to input and inputs, like jq -n. It requires an input format
+	// that feeds inputs.
+	NullInput bool
 
-		// Parse params as a list of bare identifiers
-		var params []string
-		if paramsAttr := bodyContent.Attributes["params"]; paramsAttr != nil {
-			// Parse the params expression as a tuple of identifiers
-			parsedParams, paramDiags := parseParamsList(paramsAttr.Expr)
-			diags = diags.Extend(paramDiags)
-			if paramDiags.HasErrors() {
-				continue
-			}
-			params = parsedParams
-		}
+	// CSV configures the csv and tsv input formats
+	CSV CSVOptions
 
-		// Get query as a string
-		var query string
-		if queryAttr := bodyContent.Attributes["query"]; queryAttr != nil {
-			// Query should be a string literal
-			queryVal, queryDiags := queryAttr.Expr.Value(nil)
-			diags = diags.Extend(queryDiags)
-			if queryDiags.HasErrors() {
-				continue
-			}
-			if queryVal.Type() != cty.String {
-				diags = diags.Append(&hcl.Diagnostic{
-					Severity: hcl.DiagError,
-					Summary:  "Invalid query type",
-					Detail:   "Query must be a string literal",
-					Subject:  queryAttr.Expr.Range().Ptr(),
-				})
-				continue
-			}
-			query = queryVal.AsString()
-		}
+	// JSON configures the JSON text of results
+	JSON JSONOptions
 
-		// Validate that query is not empty
-		if query == "" {
-			diags = diags.Append(&hcl.Diagnostic{
-				Severity: hcl.DiagError,
-				Summary:  "Missing query",
-				Detail:   fmt.Sprintf("%s blocks must specify a 'query' attribute", blockType),
-				Subject:  &block.DefRange,
-			})
-			continue
-		}
+	// StrictJSON, when not nil, parses JSON string input strictly
+	StrictJSON *StrictJSONOptions
 
-		// Create and compile the function
-		funcDef := &jqFunctionDef{
-			Name:   block.Labels[0],
-			Params: params,
-			Query:  query,
-			Range:  block.DefRange,
-		}
+	// compilerOptions are kept to compile the query again with the inputs
+	// of a call, for input formats that feed inputs
+	compilerOptions []gojq.CompilerOption
 
-		compiledFunc, compileDiags := compileJqFunction(funcDef)
-		diags = diags.Extend(compileDiags)
-		if compileDiags.HasErrors() {
-			continue // Skip this function but continue with others
+	// fedQueries pools the *fedQuery values of input formats that feed
+	// inputs, so that calls compile the query again only when they overlap
+	fedQueries *sync.Pool
+}
+
+// converter returns the value converter configured for this function
+func (jqFunc *JqFunction) converter() *converter {
+	capsules := jqFunc.Converters
+	if capsules == nil {
+		capsules = DefaultConverters
+	}
+	c := &converter{
+		inferCollections: jqFunc.InferCollections,
+		capsules:         capsules,
+	}
+	if jqFunc.JSON.PreserveOrder {
+		c.keyOrder = newKeyOrder()
+	}
+	return c
+}
+
+// New builds an HCL function from a jq query without HCL source. The params
+// are the names of the arguments after the input, available in the query as
+// $-prefixed variables. Options correspond to the optional attributes of jq
+// blocks; the function behaves exactly like one decoded by DecodeJqFunctions.
+func New(name string, query string, params []string, opts ...Option) (function.Function, error) {
+	jqFunc, err := Compile(name, query, params, opts...)
+	if err != nil {
+		return function.Function{}, err
+	}
+	return jqFunc.Function(), nil
+}
+
+// Compile compiles a jq query into a JqFunction without HCL source. It
+// accepts the same arguments as New.
+func Compile(name string, query string, params []string, opts ...Option) (*JqFunction, error) {
+	cfg := newConfig(opts)
+
+	if !cfg.results.valid() {
+		return nil, fmt.Errorf("jq function %s: invalid result mode %q", name, cfg.results)
+	}
+	if !cfg.inputFormat.validInput() {
+		return nil, fmt.Errorf("jq function %s: invalid input format %q", name, cfg.inputFormat)
+	}
+	if !cfg.outputFormat.validOutput() {
+		return nil, fmt.Errorf("jq function %s: invalid output format %q", name, cfg.outputFormat)
+	}
+	if query == "" {
+		return nil, fmt.Errorf("jq function %s: query must not be empty", name)
+	}
+	for _, param := range params {
+		if !hclsyntax.ValidIdentifier(param) {
+			return nil, fmt.Errorf("jq function %s: invalid parameter name %q", name, param)
 		}
+	}
 
-		// Create HCL function from compiled jq function
-		hclFunc := createHclFunction(compiledFunc)
-		hclFunctions[compiledFunc.Name] = hclFunc
+	jqFunc, diags := compileJqFunction(cfg.functionDef(name, query, params, cfg.defRange))
+	for _, diag := range diags {
+		if diag.Severity == hcl.DiagError {
+			return nil, fmt.Errorf("jq function %s: %s", name, diag.Detail)
+		}
 	}
+	return jqFunc, nil
+}
 
-	return hclFunctions, remainingBody, diags
+// Function returns the HCL function that executes this jq function. It may
+// be called concurrently, like the JqFunction itself.
+func (jqFunc *JqFunction) Function() function.Function {
+	return createHclFunction(jqFunc)
 }
 
 // jqFunctionDef represents the raw definition from HCL before compilation (internal type)
 type jqFunctionDef struct {
-	Name   string
-	Params []string
-	Query  string
-	Range  hcl.Range // For error reporting
+	Name             string
+	Params           []string
+	Query            string
+	Sensitive        bool
+	InferCollections bool
+	ReturnType       cty.Type
+	Results          ResultMode
+	Timeout          time.Duration
+	Converters       *ConverterRegistry
+	InputFormat      Format
+	OutputFormat     Format
+	NullInput        bool
+	CSV              CSVOptions
+	JSON             JSONOptions
+	StrictJSON       *StrictJSONOptions
+	CompilerOptions  []gojq.CompilerOption
+	// CompilerOptionsKey identifies CompilerOptions in CompileCache keys
+	CompilerOptionsKey string
+	CompileCache       *CompileCache
+	Range              hcl.Range // For error reporting
 }
 
-// parseParamsList parses a params expression as a tuple/list of bare identifiers
-func parseParamsList(expr hcl.Expression) ([]string, hcl.Diagnostics) {
+// compileJqFunction compiles a jq function definition with parameter variables (internal function)
+func compileJqFunction(funcDef *jqFunctionDef) (*JqFunction, hcl.Diagnostics) {
 	var diags hcl.Diagnostics
 
-	// Try to parse as a tuple expression (list of identifiers)
-	if tupleExpr, ok := expr.(*hclsyntax.TupleConsExpr); ok {
-		var params []string
-		for _, elemExpr := range tupleExpr.Exprs {
-			// Each element should be a variable expression (bare identifier)
-			if varExpr, ok := elemExpr.(*hclsyntax.ScopeTraversalExpr); ok {
-				// Check that it's a simple identifier (no dots)
-				if len(varExpr.Traversal) == 1 {
-					if step, ok := varExpr.Traversal[0].(hcl.TraverseRoot); ok {
-						params = append(params, step.Name)
-						continue
-					}
-				}
-			}
+	if diag := checkFormats(funcDef); diag != nil {
+		return nil, diags.Append(diag)
+	}
 
-			// If we get here, the element is not a simple identifier
-			diags = diags.Append(&hcl.Diagnostic{
-				Severity: hcl.DiagError,
-				Summary:  "Invalid parameter",
-				Detail:   "Parameters must be bare identifiers (e.g., [a, b, c])",
-				Subject:  elemExpr.Range().Ptr(),
-			})
+	// Reuse an earlier compilation of the same query when possible
+	cache := funcDef.CompileCache
+	if len(funcDef.CompilerOptions) > 0 && funcDef.CompilerOptionsKey == "" {
+		cache = nil
+	}
+	var key cacheKey
+	var compiledQuery *gojq.Code
+	if cache != nil {
+		key = compileCacheKey(funcDef.Query, funcDef.Params, funcDef.CompilerOptionsKey, funcDef.InputFormat.feedsInputs())
+		if cached, ok := cache.get(key); ok {
+			compiledQuery = cached.(*gojq.Code)
 		}
-		return params, diags
 	}
 
-	// If it's not a tuple, it might be an empty list or invalid syntax
-	diags = diags.Append(&hcl.Diagnostic{
-		Severity: hcl.DiagError,
-		Summary:  "Invalid params syntax",
-		Detail:   "params must be a list of bare identifiers, e.g., params = [a, b, c]",
-		Subject:  expr.Range().Ptr(),
-	})
+	if compiledQuery == nil {
+		var diag *hcl.Diagnostic
+		compiledQuery, diag = compileQuery(funcDef)
+		if diag != nil {
+			return nil, diags.Append(diag)
+		}
+		if cache != nil {
+			cache.put(key, compiledQuery)
+		}
+	}
 
-	return nil, diags
+	var fedQueries *sync.Pool
+	if funcDef.InputFormat.feedsInputs() {
+		fedQueries = &sync.Pool{}
+	}
+
+	return &JqFunction{
+		Name:             funcDef.Name,
+		Params:           funcDef.Params,
+		Query:            funcDef.Query,
+		CompiledQuery:    compiledQuery,
+		Sensitive:        funcDef.Sensitive,
+		Range:            funcDef.Range,
+		InferCollections: funcDef.InferCollections,
+		ReturnType:       funcDef.ReturnType,
+		Results:          funcDef.Results,
+		Timeout:          funcDef.Timeout,
+		Converters:       funcDef.Converters,
+		InputFormat:      funcDef.InputFormat,
+		OutputFormat:     funcDef.OutputFormat,
+		NullInput:        funcDef.NullInput,
+		CSV:              funcDef.CSV,
+		JSON:             funcDef.JSON,
+		StrictJSON:       funcDef.StrictJSON,
+		compilerOptions:  funcDef.CompilerOptions,
+		fedQueries:       fedQueries,
+	}, diags
 }
 
-// compileJqFunction compiles a jq function definition with parameter variables (internal function)
-func compileJqFunction(funcDef *jqFunctionDef) (*JqFunction, hcl.Diagnostics) {
-	var diags hcl.Diagnostics
+// checkFormats validates the input and output formats of a function
+// definition
+func checkFormats(funcDef *jqFunctionDef) *hcl.Diagnostic {
+	var detail string
+	switch {
+	case !funcDef.InputFormat.validInput():
+		detail = fmt.Sprintf("input_format must be one of %s, got %q", formatList(inputFormats), funcDef.InputFormat)
+	case !funcDef.OutputFormat.validOutput():
+		detail = fmt.Sprintf("output_format must be one of %s, got %q", formatList(outputFormats), funcDef.OutputFormat)
+	case funcDef.OutputFormat != "" && funcDef.ReturnType != cty.NilType && funcDef.ReturnType != cty.String:
+		detail = fmt.Sprintf("output_format produces strings, so return_type must be string, not %s", funcDef.ReturnType.FriendlyName())
+	case funcDef.NullInput && !funcDef.InputFormat.feedsInputs():
+		detail = fmt.Sprintf("null_input requires input_format = %q", FormatNDJSON)
+	case funcDef.CSV != CSVOptions{} && funcDef.InputFormat != FormatCSV && funcDef.InputFormat != FormatTSV:
+		detail = fmt.Sprintf("csv options require input_format = %q or %q", FormatCSV, FormatTSV)
+	case funcDef.CSV.Delimiter != 0 && !validDelimiter(funcDef.CSV.Delimiter):
+		detail = fmt.Sprintf("invalid csv delimiter %q", funcDef.CSV.Delimiter)
+	case funcDef.JSON != JSONOptions{} && funcDef.OutputFormat == FormatYAML:
+		detail = fmt.Sprintf("json options require JSON output, not output_format = %q", funcDef.OutputFormat)
+	case funcDef.JSON.PreserveOrder && !funcDef.InputFormat.isJSON():
+		detail = fmt.Sprintf("preserve_order requires JSON input, not input_format = %q", funcDef.InputFormat)
+	case funcDef.JSON.Indent < 0 || funcDef.JSON.Indent > maxJSONIndent:
+		detail = fmt.Sprintf("json indent must be between 0 and %d, got %d", maxJSONIndent, funcDef.JSON.Indent)
+	case funcDef.StrictJSON != nil && !funcDef.InputFormat.isJSON():
+		detail = fmt.Sprintf("strict_json requires JSON input, not input_format = %q", funcDef.InputFormat)
+	case funcDef.StrictJSON != nil && funcDef.StrictJSON.MaxSize < 0:
+		detail = fmt.Sprintf("strict_json max_size must not be negative, got %d", funcDef.StrictJSON.MaxSize)
+	case funcDef.StrictJSON != nil && (funcDef.StrictJSON.MaxDepth < 0 || funcDef.StrictJSON.MaxDepth > maxJSONDepth):
+		detail = fmt.Sprintf("strict_json max_depth must be between 0 and %d, got %d", maxJSONDepth, funcDef.StrictJSON.MaxDepth)
+	default:
+		return nil
+	}
+	return &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  "Invalid format",
+		Detail:   detail,
+		Subject:  &funcDef.Range,
+	}
+}
 
+// compileQuery parses and compiles the query of a function definition
+func compileQuery(funcDef *jqFunctionDef) (*gojq.Code, *hcl.Diagnostic) {
 	// Parse the jq query
 	query, err := gojq.Parse(funcDef.Query)
 	if err != nil {
-		diags = diags.Append(&hcl.Diagnostic{
+		return nil, &hcl.Diagnostic{
 			Severity: hcl.DiagError,
 			Summary:  "Invalid jq query",
 			Detail:   fmt.Sprintf("Failed to parse jq query: %s", err),
 			Subject:  &funcDef.Range,
-		})
-		return nil, diags
-	}
-
-	// Create variable names with parameter names prefixed with "$"
-	var variables []string
-	for _, param := range funcDef.Params {
-		variables = append(variables, "$"+param)
+		}
 	}
 
-	// Compile the query with the parameter variables
-	var compiledQuery *gojq.Code
-	if len(variables) > 0 {
-		compiledQuery, err = gojq.Compile(query, gojq.WithVariables(variables))
-	} else {
-		// No parameters, compile without variables
-		compiledQuery, err = gojq.Compile(query)
+	// Allow input and inputs for formats that feed them; calls use
+	// compilations of their own from the function's pool
+	compilerOptions := funcDef.CompilerOptions
+	if funcDef.InputFormat.feedsInputs() {
+		compilerOptions = append([]gojq.CompilerOption{gojq.WithInputIter(&inputSource{})}, compilerOptions...)
 	}
 
+	compiledQuery, err := compileParsed(query, funcDef.Params, compilerOptions)
 	if err != nil {
-		diags = diags.Append(&hcl.Diagnostic{
+		return nil, &hcl.Diagnostic{
 			Severity: hcl.DiagError,
 			Summary:  "Failed to compile jq query",
 			Detail:   fmt.Sprintf("Failed to compile jq query with variables: %s", err),
 			Subject:  &funcDef.Range,
-		})
-		return nil, diags
+		}
 	}
+	return compiledQuery, nil
+}
 
-	return &JqFunction{
-		Name:          funcDef.Name,
-		Params:        funcDef.Params,
-		Query:         funcDef.Query,
-		CompiledQuery: compiledQuery,
-		Range:         funcDef.Range,
-	}, diags
+// compileParsed compiles a parsed query with the parameter variables and any
+// extra options
+func compileParsed(query *gojq.Query, params []string, opts []gojq.CompilerOption) (*gojq.Code, error) {
+	// Create variable names with parameter names prefixed with "$"
+	var variables []string
+	for _, param := range params {
+		variables = append(variables, "$"+param)
+	}
+
+	var compilerOptions []gojq.CompilerOption
+	if len(variables) > 0 {
+		compilerOptions = append(compilerOptions, gojq.WithVariables(variables))
+	}
+	return gojq.Compile(query, append(compilerOptions, opts...)...)
 }
 
 // createHclFunction creates an HCL function from a compiled jq function
 func createHclFunction(jqFunc *JqFunction) function.Function {
-	// Build parameter list: first parameter accepts any type, then user-defined parameters (any type)
+	// Build parameter list: first parameter accepts any type, then user-defined parameters (any type).
+	// Marked values are accepted so that marks nested inside collections can be
+	// collected by executeJqFunction rather than rejected by cty.
 	params := []function.Parameter{
 		{
-			Name: "input",
-			Type: cty.DynamicPseudoType, // Accept any type
+			Name:        "input",
+			Type:        cty.DynamicPseudoType, // Accept any type
+			AllowMarked: true,
 		},
 	}
 
 	// Add user-defined parameters (all accept any type)
 	for _, paramName := range jqFunc.Params {
 		params = append(params, function.Parameter{
-			Name: paramName,
-			Type: cty.DynamicPseudoType, // Accept any type
+			Name:        paramName,
+			Type:        cty.DynamicPseudoType, // Accept any type
+			AllowMarked: true,
 		})
 	}
 
+	// Can return any type unless a return type was declared
+	returnType := cty.DynamicPseudoType
+	if jqFunc.ReturnType != cty.NilType {
+		returnType = jqFunc.ReturnType
+	}
+
 	return function.New(&function.Spec{
 		Params: params,
-		Type:   function.StaticReturnType(cty.DynamicPseudoType), // Can return any type
+		Type:   function.StaticReturnType(returnType),
 		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
 			return executeJqFunction(jqFunc, args)
 		},
 	})
 }
 
-// executeJqFunction executes a compiled jq function with the provided arguments
+// executeJqFunction executes a compiled jq function with the provided arguments.
+// Marks on the arguments (at any depth) are removed before conversion and their
+// union is re-applied to the result.
 func executeJqFunction(jqFunc *JqFunction, args []cty.Value) (cty.Value, error) {
-	// Prepare the input for jq processing
-	var jqInput interface{}
-	var isStringInput bool
-
-	if args[0].Type() == cty.String {
-		// String input: parse as JSON
-		jsonStr := args[0].AsString()
-		if err := json.Unmarshal([]byte(jsonStr), &jqInput); err != nil {
-			return cty.NilVal, &JqExecutionError{
-				FunctionName: jqFunc.Name,
-				Query:        jqFunc.Query,
-				Range:        jqFunc.Range,
-				Cause:        fmt.Errorf("invalid JSON input: %v", err),
+	args, marks := unmarkArgs(args)
+	if jqFunc.Sensitive {
+		marks[SensitiveMark] = struct{}{}
+	}
+
+	result, err := executeUnmarked(jqFunc, args)
+	if err != nil {
+		return cty.NilVal, err
+	}
+	return result.WithMarks(marks), nil
+}
+
+// unmarkArgs deeply unmarks each argument, returning the unmarked values and
+// the union of all marks found.
+func unmarkArgs(args []cty.Value) ([]cty.Value, cty.ValueMarks) {
+	marks := make(cty.ValueMarks)
+	unmarked := make([]cty.Value, len(args))
+	for i, arg := range args {
+		var argMarks cty.ValueMarks
+		unmarked[i], argMarks = arg.UnmarkDeep()
+		for mark := range argMarks {
+			marks[mark] = struct{}{}
+		}
+	}
+	return unmarked, marks
+}
+
+// executeUnmarked runs the query on arguments that carry no marks
+func executeUnmarked(jqFunc *JqFunction, args []cty.Value) (cty.Value, error) {
+	converter := jqFunc.converter()
+
+	jqInputs, variableValues, isStringInput, err := jqFunc.prepareArgs(converter, args)
+	if err != nil {
+		return cty.NilVal, err
+	}
+
+	// Execute the compiled jq query with variables as variadic arguments
+	results, err := jqFunc.execute(context.Background(), jqInputs, variableValues)
+	if err != nil {
+		return cty.NilVal, err
+	}
+	if jqFunc.OutputFormat == FormatNDJSON {
+		// Each result is a line of its own rather than part of one combined
+		// result
+		var lines []byte
+		for _, result := range results {
+			line, err := encodeOutput(FormatNDJSON, result, jqFunc.JSON.forCall(converter))
+			if err != nil {
+				return cty.NilVal, jqFunc.executionError(fmt.Errorf("failed to marshal result: %v", err))
 			}
+			lines = append(lines, line...)
+		}
+		return cty.StringVal(string(lines)), nil
+	}
+	finalResult := jqFunc.combineResults(results)
+
+	// The declared return type gives empty collections their element type.
+	// Without one, the static type of the input restores capsules, but says
+	// nothing of the shape of the result, so it only types an empty result
+	// of an empty input.
+	hint := cty.DynamicPseudoType
+	if jqFunc.ReturnType != cty.NilType {
+		hint = jqFunc.ReturnType
+	} else if !isStringInput && len(results) == 1 && jqFunc.Results != ResultsArray {
+		hint = args[0].Type()
+		converter.capsuleHintsOnly = !isEmptyCollection(args[0])
+	}
+	return jqFunc.resultToCty(converter, finalResult, isStringInput, hint)
+}
+
+// prepareArgs converts unmarked arguments for gojq. A string input is parsed
+// in the input format, which may yield any number of inputs; any other input,
+// including a null string, and all parameters, are converted from cty.
+func (jqFunc *JqFunction) prepareArgs(converter *converter, args []cty.Value) ([]interface{}, []interface{}, bool, error) {
+	// Prepare the input for jq processing
+	var jqInputs []interface{}
+	isStringInput := args[0].Type() == cty.String && !args[0].IsNull()
+
+	if isStringInput {
+		// String input: parse in the input format
+		var err error
+		if jqInputs, err = jqFunc.decodeInput([]byte(args[0].AsString()), converter); err != nil {
+			return nil, nil, false, jqFunc.executionError(fmt.Errorf("invalid %s input: %v", jqFunc.InputFormat.displayName(), err))
 		}
-		isStringInput = true
 	} else {
 		// Non-string input: convert from cty to Go value
-		var err error
-		jqInput, err = go2cty2go.CtyToAny(args[0])
+		jqInput, err := converter.ctyToJq(args[0])
 		if err != nil {
-			return cty.NilVal, &JqExecutionError{
-				FunctionName: jqFunc.Name,
-				Query:        jqFunc.Query,
-				Range:        jqFunc.Range,
-				Cause:        fmt.Errorf("failed to convert input: %v", err),
-			}
+			return nil, nil, false, jqFunc.executionError(fmt.Errorf("failed to convert input: %v", err))
 		}
-		isStringInput = false
+		jqInputs = []interface{}{jqInput}
 	}
 
 	// Convert remaining arguments from cty to Go values in the same order as parameters
 	var variableValues []interface{}
 	for i, paramName := range jqFunc.Params {
-		argValue, err := go2cty2go.CtyToAny(args[i+1])
+		argValue, err := converter.ctyToJq(args[i+1])
 		if err != nil {
-			return cty.NilVal, &JqExecutionError{
-				FunctionName: jqFunc.Name,
-				Query:        jqFunc.Query,
-				Range:        jqFunc.Range,
-				Cause:        fmt.Errorf("failed to convert parameter %s: %v", paramName, err),
-			}
+			return nil, nil, false, jqFunc.executionError(fmt.Errorf("failed to convert parameter %s: %v", paramName, err))
 		}
 		variableValues = append(variableValues, argValue)
 	}
+	return jqInputs, variableValues, isStringInput, nil
+}
 
-	// Execute the compiled jq query with variables as variadic arguments
-	var iter gojq.Iter
-	if len(variableValues) > 0 {
-		iter = jqFunc.CompiledQuery.RunWithContext(context.Background(), jqInput, variableValues...)
-	} else {
-		iter = jqFunc.CompiledQuery.RunWithContext(context.Background(), jqInput)
+// resultToCty converts a result to the value the function returns
+func (jqFunc *JqFunction) resultToCty(converter *converter, result interface{}, isStringInput bool, hint cty.Type) (cty.Value, error) {
+	// Return result based on input type. A declared return type other than
+	// string always produces a cty value, even for JSON string input, while
+	// an output format always produces a string.
+	outputFormat := jqFunc.OutputFormat
+	if outputFormat == "" && isStringInput && (jqFunc.ReturnType == cty.NilType || jqFunc.ReturnType == cty.String) {
+		outputFormat = FormatJSON
 	}
-
-	// Collect all results from the iterator
-	var results []interface{}
-	for {
-		result, hasResult := iter.Next()
-		if !hasResult {
-			break
-		}
-
-		// Check for execution error
-		if err, ok := result.(error); ok {
-			return cty.NilVal, &JqExecutionError{
-				FunctionName: jqFunc.Name,
-				Query:        jqFunc.Query,
-				Range:        jqFunc.Range,
-				Cause:        fmt.Errorf("jq execution error: %v", err),
-			}
+	if outputFormat != "" {
+		// Special case: if the final result is a string, return it directly
+		// This is more useful than encoding it (which would add quotes), but
+		// every NDJSON line must be a JSON value
+		if str, ok := result.(string); ok && outputFormat != FormatNDJSON {
+			return cty.StringVal(str), nil
 		}
 
-		results = append(results, result)
-	}
-
-	// Handle no results
-	if len(results) == 0 {
-		if isStringInput {
-			return cty.StringVal("null"), nil
-		} else {
-			return cty.NullVal(cty.DynamicPseudoType), nil
+		// For non-string results: encode the result in the output format
+		encoded, err := encodeOutput(outputFormat, result, jqFunc.JSON.forCall(converter))
+		if err != nil {
+			return cty.NilVal, jqFunc.executionError(fmt.Errorf("failed to marshal result: %v", err))
 		}
+		return cty.StringVal(string(encoded)), nil
 	}
 
-	// Determine the final result based on number of results
-	var finalResult interface{}
-	if len(results) == 1 {
-		// Single result: return the element directly
-		finalResult = results[0]
-	} else {
-		// Multiple results: return as array
-		finalResult = results
+	// Convert result back to cty value
+	ctyResult, err := converter.jqToCty(result, hint)
+	if err != nil {
+		return cty.NilVal, jqFunc.executionError(fmt.Errorf("failed to convert result: %v", err))
 	}
 
-	// Return result based on input type
-	if isStringInput {
-		// Special case: if the final result is a string, return it directly
-		// This is more useful than JSON-encoding it (which would add quotes)
-		if str, ok := finalResult.(string); ok {
-			return cty.StringVal(str), nil
-		}
-
-		// For non-string results: marshal result back to JSON string
-		resultJSON, err := json.Marshal(finalResult)
-		if err != nil {
-			return cty.NilVal, &JqExecutionError{
-				FunctionName: jqFunc.Name,
-				Query:        jqFunc.Query,
-				Range:        jqFunc.Range,
-				Cause:        fmt.Errorf("failed to marshal result: %v", err),
-			}
-		}
-		return cty.StringVal(string(resultJSON)), nil
-	} else {
-		// Non-string input: convert result back to cty value
-		ctyResult, err := go2cty2go.AnyToCty(finalResult)
+	if jqFunc.ReturnType != cty.NilType {
+		ctyResult, err = convert.Convert(ctyResult, jqFunc.ReturnType)
 		if err != nil {
-			return cty.NilVal, &JqExecutionError{
-				FunctionName: jqFunc.Name,
-				Query:        jqFunc.Query,
-				Range:        jqFunc.Range,
-				Cause:        fmt.Errorf("failed to convert result: %v", err),
-			}
+			return cty.NilVal, jqFunc.executionError(fmt.Errorf("result does not match declared return type %s: %v", converter.capsules.typeString(jqFunc.ReturnType), err))
 		}
-		return ctyResult, nil
 	}
+	return ctyResult, nil
 }
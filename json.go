@@ -0,0 +1,843 @@
+package jqfunc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// JSON string input is decoded straight into the values gojq works with, and
+// results are encoded straight from them, without going through
+// encoding/json's reflection and an intermediate tree of json.Number values.
+// Both follow encoding/json's behavior otherwise: invalid UTF-8 and unpaired
+// surrogates become U+FFFD, the last of duplicate object keys wins (unless
+// parsing strictly), and the output escapes HTML characters and sorts object
+// keys (unless JSONOptions say otherwise). The decoder also
+// reads the JSONC and JSON5 dialects, in place so that error offsets refer
+// to the original text.
+
+// maxJSONDepth limits nesting like encoding/json does
+const maxJSONDepth = 10000
+
+// document parses the whole input as a single document
+func (d *jsonDecoder) document() (interface{}, error) {
+	d.skipSpace()
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	d.skipSpace()
+	if d.pos < len(d.data) {
+		return nil, fmt.Errorf("unexpected data after top-level value at offset %d", d.pos)
+	}
+	return v, nil
+}
+
+// stream parses the whole input as a sequence of documents separated by
+// whitespace, such as newline-delimited JSON, or by the record separators of
+// JSON text sequences. Errors give the line, counting from 1.
+func (d *jsonDecoder) stream() ([]interface{}, error) {
+	var values []interface{}
+	for {
+		for d.pos < len(d.data) && (d.data[d.pos] == '\x1e' || isJSONSpace(d.data[d.pos])) {
+			d.pos++
+		}
+		if d.pos >= len(d.data) {
+			return values, nil
+		}
+
+		v, err := d.value(0)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", 1+bytes.Count(d.data[:min(d.pos, len(d.data))], []byte{'\n'}), err)
+		}
+		values = append(values, v)
+	}
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// jsonDecoder is a recursive-descent parser over a complete JSON document
+type jsonDecoder struct {
+	data    []byte
+	pos     int
+	dialect jsonDialect
+
+	// order, when set, records the key order of objects
+	order *keyOrder
+
+	// decimals, when set, records the exact values of numbers that a
+	// float64 only approximates
+	decimals *decimals
+
+	// maxDepth limits the nesting of arrays and objects
+	maxDepth int
+
+	// strict rejects duplicate object keys and escaped unpaired surrogates
+	strict bool
+}
+
+// newJSONDecoder returns a decoder for data in the given dialect. JSONC and
+// JSON5 documents may start with a byte order mark.
+func newJSONDecoder(data []byte, dialect jsonDialect, order *keyOrder) *jsonDecoder {
+	d := &jsonDecoder{data: data, dialect: dialect, order: order, maxDepth: maxJSONDepth}
+	if dialect != dialectJSON && bytes.HasPrefix(data, utf8BOM) {
+		d.pos = len(utf8BOM)
+	}
+	return d
+}
+
+func (d *jsonDecoder) skipSpace() {
+	for d.pos < len(d.data) && isJSONSpace(d.data[d.pos]) {
+		d.pos++
+	}
+	if d.dialect != dialectJSON {
+		d.skipComments()
+	}
+}
+
+// syntaxError reports the byte at the current position as unexpected
+func (d *jsonDecoder) syntaxError(context string) error {
+	if d.pos >= len(d.data) {
+		return fmt.Errorf("unexpected end of JSON input")
+	}
+	if d.dialect != dialectJSON && bytes.HasPrefix(d.data[d.pos:], []byte("/*")) {
+		// skipComments stops at a comment that is never closed
+		return fmt.Errorf("unterminated comment at offset %d", d.pos)
+	}
+	return fmt.Errorf("invalid character %q %s at offset %d", d.data[d.pos], context, d.pos)
+}
+
+func (d *jsonDecoder) value(depth int) (interface{}, error) {
+	if d.pos >= len(d.data) {
+		return nil, d.syntaxError("")
+	}
+
+	switch c := d.data[d.pos]; {
+	case c == '{':
+		if depth >= d.maxDepth {
+			return nil, fmt.Errorf("exceeded max depth at offset %d", d.pos)
+		}
+		return d.object(depth + 1)
+	case c == '[':
+		if depth >= d.maxDepth {
+			return nil, fmt.Errorf("exceeded max depth at offset %d", d.pos)
+		}
+		return d.array(depth + 1)
+	case c == '"':
+		return d.string()
+	case c == '-' || ('0' <= c && c <= '9'):
+		if d.dialect == dialectJSON5 {
+			return d.json5Number()
+		}
+		return d.number()
+	case c == 't':
+		return true, d.literal("true")
+	case c == 'f':
+		return false, d.literal("false")
+	case c == 'n':
+		return nil, d.literal("null")
+	case d.dialect == dialectJSON5:
+		return d.json5Value()
+	default:
+		return nil, d.syntaxError("looking for beginning of value")
+	}
+}
+
+func (d *jsonDecoder) literal(lit string) error {
+	for i := 0; i < len(lit); i++ {
+		if d.pos >= len(d.data) || d.data[d.pos] != lit[i] {
+			return d.syntaxError(fmt.Sprintf("in literal %s", lit))
+		}
+		d.pos++
+	}
+	return nil
+}
+
+func (d *jsonDecoder) object(depth int) (interface{}, error) {
+	d.pos++ // '{'
+	obj := make(map[string]interface{})
+	var keys []string
+
+	d.skipSpace()
+	if d.pos < len(d.data) && d.data[d.pos] == '}' {
+		d.pos++
+		return obj, nil
+	}
+
+	for {
+		keyStart := d.pos
+		key, err := d.objectKey()
+		if err != nil {
+			return nil, err
+		}
+		if d.strict {
+			if _, dup := obj[key]; dup {
+				return nil, fmt.Errorf("duplicate key %q at offset %d", key, keyStart)
+			}
+		}
+
+		d.skipSpace()
+		if d.pos >= len(d.data) || d.data[d.pos] != ':' {
+			return nil, d.syntaxError("after object key")
+		}
+		d.pos++
+		d.skipSpace()
+
+		v, err := d.value(depth)
+		if err != nil {
+			return nil, err
+		}
+		if d.order != nil {
+			d.order.see(key)
+			if _, dup := obj[key]; !dup {
+				keys = append(keys, key)
+			}
+		}
+		obj[key] = v
+
+		d.skipSpace()
+		if d.pos < len(d.data) {
+			switch d.data[d.pos] {
+			case ',':
+				d.pos++
+				d.skipSpace()
+				if d.trailingComma('}') {
+					d.recordKeys(keys)
+					return obj, nil
+				}
+				continue
+			case '}':
+				d.pos++
+				d.recordKeys(keys)
+				return obj, nil
+			}
+		}
+		return nil, d.syntaxError("after object key:value pair")
+	}
+}
+
+// recordKeys records the keys of an object, when recording key order
+func (d *jsonDecoder) recordKeys(keys []string) {
+	if d.order != nil {
+		d.order.add(keys)
+	}
+}
+
+func (d *jsonDecoder) array(depth int) (interface{}, error) {
+	d.pos++ // '['
+	arr := []interface{}{}
+
+	d.skipSpace()
+	if d.pos < len(d.data) && d.data[d.pos] == ']' {
+		d.pos++
+		return arr, nil
+	}
+
+	for {
+		v, err := d.value(depth)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+
+		d.skipSpace()
+		if d.pos < len(d.data) {
+			switch d.data[d.pos] {
+			case ',':
+				d.pos++
+				d.skipSpace()
+				if d.trailingComma(']') {
+					return arr, nil
+				}
+				continue
+			case ']':
+				d.pos++
+				return arr, nil
+			}
+		}
+		return nil, d.syntaxError("after array element")
+	}
+}
+
+// objectKey parses the key of an object member
+func (d *jsonDecoder) objectKey() (string, error) {
+	if d.pos < len(d.data) && d.data[d.pos] == '"' {
+		return d.string()
+	}
+	if d.dialect == dialectJSON5 {
+		return d.json5Key()
+	}
+	return "", d.syntaxError("looking for beginning of object key string")
+}
+
+// string parses a string literal, returning its value as a Go string. The
+// literal ends with the quote it starts with, which in JSON5 may be '.
+func (d *jsonDecoder) string() (string, error) {
+	quote := d.data[d.pos]
+	d.pos++ // opening quote
+	start := d.pos
+
+	// Fast path: plain ASCII without escapes is used as is
+	for d.pos < len(d.data) {
+		c := d.data[d.pos]
+		if c == quote {
+			d.pos++
+			return string(d.data[start : d.pos-1]), nil
+		}
+		if c == '\\' || c < 0x20 || c >= utf8.RuneSelf {
+			break
+		}
+		d.pos++
+	}
+
+	buf := append(make([]byte, 0, d.pos-start+16), d.data[start:d.pos]...)
+	for d.pos < len(d.data) {
+		c := d.data[d.pos]
+		switch {
+		case c == quote:
+			d.pos++
+			return string(buf), nil
+		case c == '\\':
+			var err error
+			if buf, err = d.escape(buf); err != nil {
+				return "", err
+			}
+		case c < 0x20 && (d.dialect != dialectJSON5 || c == '\n' || c == '\r'):
+			return "", d.syntaxError("in string literal")
+		case c < utf8.RuneSelf:
+			buf = append(buf, c)
+			d.pos++
+		default:
+			r, size := utf8.DecodeRune(d.data[d.pos:])
+			buf = utf8.AppendRune(buf, r) // RuneError for invalid UTF-8
+			d.pos += size
+		}
+	}
+	return "", d.syntaxError("")
+}
+
+// escape decodes the escape sequence at the current position
+func (d *jsonDecoder) escape(buf []byte) ([]byte, error) {
+	d.pos++ // backslash
+	if d.pos >= len(d.data) {
+		return nil, d.syntaxError("")
+	}
+
+	c := d.data[d.pos]
+	d.pos++
+	switch c {
+	case '"', '\\', '/':
+		return append(buf, c), nil
+	case 'b':
+		return append(buf, '\b'), nil
+	case 'f':
+		return append(buf, '\f'), nil
+	case 'n':
+		return append(buf, '\n'), nil
+	case 'r':
+		return append(buf, '\r'), nil
+	case 't':
+		return append(buf, '\t'), nil
+	case 'u':
+		r, err := d.hex4()
+		if err != nil {
+			return nil, err
+		}
+		if utf16.IsSurrogate(r) {
+			// A high surrogate must be followed by an escaped low surrogate
+			start := d.pos - 6
+			r2 := utf8.RuneError
+			if d.pos+1 < len(d.data) && d.data[d.pos] == '\\' && d.data[d.pos+1] == 'u' {
+				save := d.pos
+				d.pos += 2
+				low, err := d.hex4()
+				if err != nil {
+					return nil, err
+				}
+				if r2 = utf16.DecodeRune(r, low); r2 == utf8.RuneError {
+					d.pos = save
+				}
+			}
+			if r2 == utf8.RuneError && d.strict {
+				return nil, fmt.Errorf("unpaired surrogate in string escape at offset %d", start)
+			}
+			r = r2
+		}
+		return utf8.AppendRune(buf, r), nil
+	default:
+		d.pos--
+		if d.dialect == dialectJSON5 {
+			return d.json5Escape(buf)
+		}
+		return nil, d.syntaxError("in string escape code")
+	}
+}
+
+// hex4 parses the four hex digits of a \u escape
+func (d *jsonDecoder) hex4() (rune, error) {
+	var r rune
+	for i := 0; i < 4; i++ {
+		if d.pos >= len(d.data) {
+			return 0, d.syntaxError("")
+		}
+		digit, ok := unhex(d.data[d.pos])
+		if !ok {
+			return 0, d.syntaxError("in \\u hexadecimal character escape")
+		}
+		r = r*16 + digit
+		d.pos++
+	}
+	return r, nil
+}
+
+// unhex returns the value of a hexadecimal digit
+func unhex(c byte) (rune, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return rune(c - '0'), true
+	case 'a' <= c && c <= 'f':
+		return rune(c - 'a' + 10), true
+	case 'A' <= c && c <= 'F':
+		return rune(c - 'A' + 10), true
+	default:
+		return 0, false
+	}
+}
+
+// number parses a number literal. Integer literals are kept exact; literals
+// with a fraction or exponent become float64, as in jq.
+func (d *jsonDecoder) number() (interface{}, error) {
+	start := d.pos
+	integral := true
+
+	if d.data[d.pos] == '-' {
+		d.pos++
+	}
+	switch {
+	case d.pos < len(d.data) && d.data[d.pos] == '0':
+		d.pos++
+	case d.pos < len(d.data) && '1' <= d.data[d.pos] && d.data[d.pos] <= '9':
+		d.skipDigits()
+	default:
+		return nil, d.syntaxError("in numeric literal")
+	}
+
+	if d.pos < len(d.data) && d.data[d.pos] == '.' {
+		integral = false
+		d.pos++
+		if !d.skipDigits() {
+			return nil, d.syntaxError("after decimal point in numeric literal")
+		}
+	}
+	if exponent, err := d.exponent(); err != nil {
+		return nil, err
+	} else if exponent {
+		integral = false
+	}
+
+	return d.numberValue(d.data[start:d.pos], integral), nil
+}
+
+// numberValue converts the text of a decimal number literal, recording its
+// exact value when it becomes a float64 that only approximates it
+func (d *jsonDecoder) numberValue(text []byte, integral bool) interface{} {
+	v := numberValue(text, integral)
+	if f, ok := v.(float64); ok && d.decimals != nil {
+		d.decimals.seeLiteral(f, string(text))
+	}
+	return v
+}
+
+// numberValue converts the text of a decimal number literal, which may start
+// with a minus sign
+func numberValue(text []byte, integral bool) interface{} {
+	if integral {
+		// Up to 18 digits always fit in an int64
+		if digits := text; len(digits) <= 18 || (digits[0] == '-' && len(digits) <= 19) {
+			negative := digits[0] == '-'
+			if negative {
+				digits = digits[1:]
+			}
+			var n int64
+			for _, c := range digits {
+				n = n*10 + int64(c-'0')
+			}
+			if negative {
+				n = -n
+			}
+			if math.MinInt <= n && n <= math.MaxInt {
+				return int(n)
+			}
+		}
+		return jsonNumberToJq(json.Number(text))
+	}
+
+	// Out of range literals become ±Inf, which encodes as ±MaxFloat64
+	f, _ := strconv.ParseFloat(string(text), 64)
+	return f
+}
+
+// exponent parses the exponent of a number literal, if there is one
+func (d *jsonDecoder) exponent() (bool, error) {
+	if d.pos >= len(d.data) || (d.data[d.pos] != 'e' && d.data[d.pos] != 'E') {
+		return false, nil
+	}
+	d.pos++
+	if d.pos < len(d.data) && (d.data[d.pos] == '+' || d.data[d.pos] == '-') {
+		d.pos++
+	}
+	if !d.skipDigits() {
+		return false, d.syntaxError("in exponent of numeric literal")
+	}
+	return true, nil
+}
+
+// skipDigits advances over decimal digits, reporting whether there were any
+func (d *jsonDecoder) skipDigits() bool {
+	start := d.pos
+	for d.pos < len(d.data) && '0' <= d.data[d.pos] && d.data[d.pos] <= '9' {
+		d.pos++
+	}
+	return d.pos > start
+}
+
+// JSONOptions configures the JSON text of results: those for string input
+// and of output_format = "json" and "ndjson", and those of RunJSON. The zero
+// value writes compact JSON escaped like encoding/json.
+type JSONOptions struct {
+	// JqCompatible writes JSON as the jq command does: <, > and &, U+2028
+	// and U+2029 are left as they are, DEL is escaped, and numbers are
+	// written with the shortest digits that read back the same and in
+	// exponent notation, like 1e-05 and 1e+17, when the decimal point is
+	// more than four places before the first digit or more than fifteen
+	// after the last. Integers a float64 cannot hold exactly, which jq would
+	// round, are written in full.
+	JqCompatible bool
+
+	// Indent puts each array element and object member on a line of its
+	// own, indented by this many spaces per level, up to 7 as in jq. Zero
+	// writes compact JSON, and NDJSON lines are always compact.
+	Indent int
+
+	// ASCII escapes every character outside ASCII, like jq --ascii-output
+	ASCII bool
+
+	// PreserveOrder writes object keys in the order of the input document
+	// where it can instead of sorting them: an object with the same keys as
+	// one in the input gets its order, and other objects list their keys in
+	// the order they first appear in the input, followed by new keys,
+	// sorted. It needs an input format in the JSON family.
+	PreserveOrder bool
+
+	// order is the key order recorded from the input of a call
+	order *keyOrder
+
+	// decimals are the exact values recorded from the input of a call
+	decimals *decimals
+}
+
+// forCall returns the options with the key order and decimals recorded
+// from the input of a call
+func (opts JSONOptions) forCall(c *converter) JSONOptions {
+	opts.order = c.keyOrder
+	opts.decimals = &c.decimals
+	return opts
+}
+
+// maxJSONIndent is the largest indent jq accepts
+const maxJSONIndent = 7
+
+// encodeJSON marshals a gojq result to compact JSON with the default
+// escaping, writing numbers without exponent notation
+func encodeJSON(v interface{}) ([]byte, error) {
+	return JSONOptions{}.encode(v)
+}
+
+// encode marshals a gojq result to JSON as configured
+func (opts JSONOptions) encode(v interface{}) ([]byte, error) {
+	return opts.append(make([]byte, 0, 64), v, 0)
+}
+
+// append appends the JSON encoding of a gojq value, nested level deep, to
+// buf
+func (opts JSONOptions) append(buf []byte, v interface{}, level int) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, "null"...), nil
+	case bool:
+		return strconv.AppendBool(buf, v), nil
+	case int:
+		if opts.JqCompatible && (v <= -1e16 || v >= 1e16) {
+			return opts.appendBigInt(buf, big.NewInt(int64(v))), nil
+		}
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case *big.Int:
+		return opts.appendBigInt(buf, v), nil
+	case float64:
+		if exact := opts.decimals.lookup(v); exact != nil {
+			return opts.appendDecimal(buf, exact), nil
+		}
+		return opts.appendFloat(buf, v), nil
+	case string:
+		return opts.appendString(buf, v), nil
+	case []interface{}:
+		if len(v) == 0 {
+			return append(buf, "[]"...), nil
+		}
+		buf = append(buf, '[')
+		for i, elem := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = opts.appendNewline(buf, level+1)
+			var err error
+			if buf, err = opts.append(buf, elem, level+1); err != nil {
+				return nil, err
+			}
+		}
+		buf = opts.appendNewline(buf, level)
+		return append(buf, ']'), nil
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return append(buf, "{}"...), nil
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if opts.order != nil {
+			keys = opts.order.arrange(keys)
+		}
+
+		buf = append(buf, '{')
+		for i, key := range keys {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = opts.appendNewline(buf, level+1)
+			buf = opts.appendString(buf, key)
+			buf = append(buf, ':')
+			if opts.Indent > 0 {
+				buf = append(buf, ' ')
+			}
+			var err error
+			if buf, err = opts.append(buf, v[key], level+1); err != nil {
+				return nil, err
+			}
+		}
+		buf = opts.appendNewline(buf, level)
+		return append(buf, '}'), nil
+	default:
+		// Values from custom gojq functions may be of other types
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return append(buf, encoded...), nil
+	}
+}
+
+// appendNewline starts a line indented for the given level, when indenting
+func (opts JSONOptions) appendNewline(buf []byte, level int) []byte {
+	if opts.Indent <= 0 {
+		return buf
+	}
+	buf = append(buf, '\n')
+	for i := 0; i < level*opts.Indent; i++ {
+		buf = append(buf, ' ')
+	}
+	return buf
+}
+
+// appendFloat appends a float. NaN becomes null and infinities become
+// ±MaxFloat64, as in jq.
+func (opts JSONOptions) appendFloat(buf []byte, f float64) []byte {
+	if math.IsNaN(f) {
+		return append(buf, "null"...)
+	}
+	f = math.Max(math.Min(f, math.MaxFloat64), -math.MaxFloat64)
+	if !opts.JqCompatible {
+		return strconv.AppendFloat(buf, f, 'f', -1, 64)
+	}
+	return appendJqFloat(buf, f)
+}
+
+// appendDecimal appends the exact value of a number of the input that a
+// float64 only approximates
+func (opts JSONOptions) appendDecimal(buf []byte, exact *big.Float) []byte {
+	if !opts.JqCompatible {
+		return exact.Append(buf, 'f', -1)
+	}
+	if exact.Sign() < 0 {
+		buf = append(buf, '-')
+	}
+	digits, point, _ := decimalDigits(exact.Text('e', -1))
+	return appendJqDigits(buf, digits, point)
+}
+
+// appendBigInt appends an integer. jq holds numbers as float64, so when
+// being compatible an integer a float64 holds exactly is written as jq
+// writes that float64.
+func (opts JSONOptions) appendBigInt(buf []byte, i *big.Int) []byte {
+	if opts.JqCompatible {
+		if f, accuracy := new(big.Float).SetInt(i).Float64(); accuracy == big.Exact {
+			return appendJqFloat(buf, f)
+		}
+	}
+	return i.Append(buf, 10)
+}
+
+// appendJqFloat appends a finite float as jq 1.6 formats it (jvp_dtoa_fmt):
+// the shortest digits that read back as the same float, in exponent
+// notation with a sign and at least two digits of exponent when the decimal
+// point is more than four places before the first digit or more than
+// fifteen after the last
+func appendJqFloat(buf []byte, f float64) []byte {
+	if f == 0 {
+		if math.Signbit(f) {
+			return append(buf, "-0"...)
+		}
+		return append(buf, '0')
+	}
+	if f < 0 {
+		buf = append(buf, '-')
+		f = -f
+	}
+
+	// Go finds the same shortest digits
+	digits, point, _ := decimalDigits(strconv.FormatFloat(f, 'e', -1, 64))
+	return appendJqDigits(buf, digits, point)
+}
+
+// appendJqDigits appends a positive number given by its significant digits
+// and the number of digits before the decimal point, laid out as jq does
+func appendJqDigits(buf []byte, digits string, point int) []byte {
+	switch {
+	case point <= -4 || point > len(digits)+15:
+		buf = append(buf, digits[0])
+		if len(digits) > 1 {
+			buf = append(buf, '.')
+			buf = append(buf, digits[1:]...)
+		}
+		buf = append(buf, 'e')
+		exp := point - 1
+		if exp < 0 {
+			buf = append(buf, '-')
+			exp = -exp
+		} else {
+			buf = append(buf, '+')
+		}
+		if exp < 10 {
+			buf = append(buf, '0')
+		}
+		return strconv.AppendInt(buf, int64(exp), 10)
+	case point <= 0:
+		buf = append(buf, "0."...)
+		for i := point; i < 0; i++ {
+			buf = append(buf, '0')
+		}
+		return append(buf, digits...)
+	case point >= len(digits):
+		buf = append(buf, digits...)
+		for i := len(digits); i < point; i++ {
+			buf = append(buf, '0')
+		}
+		return buf
+	default:
+		buf = append(buf, digits[:point]...)
+		buf = append(buf, '.')
+		return append(buf, digits[point:]...)
+	}
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendString appends a quoted JSON string. Control characters, quotes and
+// backslashes are always escaped, and invalid UTF-8 becomes U+FFFD. By
+// default the HTML characters <, > and & and U+2028 and U+2029 are escaped
+// too, as encoding/json does; jq escapes DEL instead.
+func (opts JSONOptions) appendString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && !opts.escapesASCII(c) {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				buf = append(buf, '\\', c)
+			case '\b':
+				buf = append(buf, '\\', 'b')
+			case '\f':
+				buf = append(buf, '\\', 'f')
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = appendUnicodeEscape(buf, rune(c))
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			buf = append(buf, s[start:i]...)
+			if opts.ASCII {
+				buf = append(buf, `\ufffd`...)
+			} else {
+				buf = append(buf, "\uFFFD"...)
+			}
+		case opts.ASCII:
+			buf = append(buf, s[start:i]...)
+			if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+				buf = appendUnicodeEscape(appendUnicodeEscape(buf, r1), r2)
+			} else {
+				buf = appendUnicodeEscape(buf, r)
+			}
+		case (r == '\u2028' || r == '\u2029') && !opts.JqCompatible:
+			buf = append(buf, s[start:i]...)
+			buf = appendUnicodeEscape(buf, r)
+		default:
+			i += size
+			continue
+		}
+		i += size
+		start = i
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
+
+// escapesASCII reports whether a printable ASCII character other than a
+// quote or backslash is escaped
+func (opts JSONOptions) escapesASCII(c byte) bool {
+	if opts.JqCompatible {
+		return c == 0x7f
+	}
+	return c == '<' || c == '>' || c == '&'
+}
+
+// appendUnicodeEscape appends a \u escape for a character of the Basic
+// Multilingual Plane or a surrogate
+func appendUnicodeEscape(buf []byte, r rune) []byte {
+	return append(buf, '\\', 'u', hexDigits[r>>12&0xF], hexDigits[r>>8&0xF], hexDigits[r>>4&0xF], hexDigits[r&0xF])
+}
@@ -0,0 +1,445 @@
+package jqfunc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// stdlibDecodeJSON and stdlibEncodeJSON are the encoding/json based
+// conversions that decodeJSON and encodeJSON replaced. They are kept as the
+// reference for the tests and benchmarks below.
+
+func stdlibDecodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			err = fmt.Errorf("unexpected data after top-level value at offset %d", dec.InputOffset())
+		}
+		return nil, err
+	}
+	return stdlibNumbers(v), nil
+}
+
+func stdlibNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		return jsonNumberToJq(v)
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = stdlibNumbers(elem)
+		}
+		return v
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = stdlibNumbers(elem)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func stdlibEncodeJSON(v interface{}) ([]byte, error) {
+	return json.Marshal(stdlibJSONNumbers(v))
+}
+
+func stdlibJSONNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return json.Number(strconv.Itoa(v))
+	case *big.Int:
+		return json.Number(v.String())
+	case float64:
+		if math.IsNaN(v) {
+			return nil
+		}
+		v = math.Max(math.Min(v, math.MaxFloat64), -math.MaxFloat64)
+		return json.Number(strconv.FormatFloat(v, 'f', -1, 64))
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, elem := range v {
+			result[i] = stdlibJSONNumbers(elem)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			result[key] = stdlibJSONNumbers(elem)
+		}
+		return result
+	default:
+		return v
+	}
+}
+
+// decodeJSON parses a single document as JSON string input is parsed
+func decodeJSON(data []byte) (interface{}, error) {
+	return decodeDocument(data, FormatJSON)
+}
+
+// decodeDocument parses a single document as string input in one of the
+// JSON formats is parsed
+func decodeDocument(data []byte, format Format) (interface{}, error) {
+	jqFunc := &JqFunction{InputFormat: format}
+	values, err := jqFunc.decodeInput(data, jqFunc.converter())
+	if err != nil {
+		return nil, err
+	}
+	return values[0], nil
+}
+
+var jsonTestDocuments = []string{
+	`null`, `true`, `false`, `0`, `-0`, `42`, `-42`,
+	`9223372036854775807`, `-9223372036854775808`, `9223372036854775808`,
+	`123456789012345678901234567890`, `-123456789012345678901234567890`,
+	`999999999999999999`, `-999999999999999999`, `1000000000000000000`,
+	`1.5`, `-0.0`, `1e3`, `1E-3`, `2.5e+10`, `1e400`, `-1e400`, `0.1e1`,
+	`""`, `"plain"`, `"esc\"aped\\\/"`, `"\b\f\n\r\t"`, `"Aé中"`,
+	`"😀"`, `"\ud83d"`, `"\ude00"`, `"\ud83dx"`, `"\ud83dA"`,
+	"\"café 中文 \U0001F600\"", "\"bad \xff utf8\"", "\"  \"",
+	`"<html> & </html>"`, `"\u0000\u001f"`,
+	`[]`, `{}`, `[1, "a", null, true, [2, [3]], {"k": {}}]`,
+	` { "b" : 1 , "a" : [ ] } `, `{"dup": 1, "dup": 2}`, `{"": 0}`,
+	`{"é": "key escapes", "<": ">"}`,
+}
+
+func TestJSONMatchesStdlib(t *testing.T) {
+	for _, doc := range jsonTestDocuments {
+		t.Run(doc, func(t *testing.T) {
+			expected, err := stdlibDecodeJSON([]byte(doc))
+			require.NoError(t, err)
+			actual, err := decodeJSON([]byte(doc))
+			require.NoError(t, err)
+			assert.Equal(t, expected, actual)
+
+			expectedJSON, err := stdlibEncodeJSON(expected)
+			require.NoError(t, err)
+			actualJSON, err := encodeJSON(actual)
+			require.NoError(t, err)
+			assert.Equal(t, string(expectedJSON), string(actualJSON))
+		})
+	}
+}
+
+func TestJSONEncodeSpecialValues(t *testing.T) {
+	values := []interface{}{
+		math.NaN(), math.Inf(1), math.Inf(-1), 1e-7, 1e21, -0.0,
+		"\x7f", string([]byte{0xe2, 0x82}), []interface{}{map[string]interface{}{"\n": "\x00"}},
+	}
+	for _, v := range values {
+		expected, err := stdlibEncodeJSON(v)
+		require.NoError(t, err)
+		actual, err := encodeJSON(v)
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), string(actual), "Encoding %#v", v)
+	}
+}
+
+func TestJSONJqCompatible(t *testing.T) {
+	// The output of jq 1.6 (jq -cS .) for each document, except for integers
+	// a float64 cannot hold exactly, which jq rounds
+	tests := []struct {
+		doc      string
+		expected string
+	}{
+		{`null`, `null`}, {`true`, `true`}, {`0`, `0`}, {`-42`, `-42`},
+		{`-9223372036854775808`, `-9223372036854776000`},
+		{`9223372036854775808`, `9223372036854776000`},
+		{`1000000000000000000`, `1e+18`},
+		{`10000000000000000`, `1e+16`},
+		{`20000000000000000`, `2e+16`},
+		{`9999999999999999`, `9999999999999999`},
+		{`9223372036854775807`, `9223372036854775807`},
+		{`123456789012345678901234567890`, `123456789012345678901234567890`},
+		{`1.5`, `1.5`}, {`-0.0`, `-0`}, {`1e3`, `1000`}, {`1E-3`, `0.001`},
+		{`2.5e+10`, `25000000000`},
+		{`1e400`, `1.7976931348623157e+308`},
+		{`-1e400`, `-1.7976931348623157e+308`},
+		{`0.1`, `0.1`}, {`0.0001`, `0.0001`}, {`0.00001`, `1e-05`}, {`-0.00001`, `-1e-05`},
+		{`1e-7`, `1e-07`}, {`-1.5e-7`, `-1.5e-07`}, {`0.000123`, `0.000123`},
+		{`1e-10`, `1e-10`}, {`5e-324`, `5e-324`},
+		{`1e15`, `1000000000000000`}, {`1e16`, `1e+16`}, {`1e17`, `1e+17`},
+		{`1.5e17`, `1.5e+17`}, {`1.234e17`, `123400000000000000`},
+		{`1e21`, `1e+21`}, {`1e100`, `1e+100`},
+		{`1.7976931348623157e308`, `1.7976931348623157e+308`},
+		{`12345.678`, `12345.678`},
+		{`0.00012345678901234567`, `0.00012345678901234567`},
+		{`3.141592653589793`, `3.141592653589793`},
+		{`"\u007f"`, `"\u007f"`},
+		{"\"<&> \\u2028\\u2029\"", "\"<&> \u2028\u2029\""},
+		{`"\ude00"`, "\"\uFFFD\""},
+		{"\"bad \xff utf8\"", "\"bad \uFFFD utf8\""},
+		{`"\b\f\n\r\t"`, `"\b\f\n\r\t"`},
+		{`"\u0000\u001f"`, `"\u0000\u001f"`},
+		{"\"caf\u00e9 \U0001F600\"", "\"caf\u00e9 \U0001F600\""},
+		{`[1, "a", null, true, [2, [3]], {"k": {}}]`, `[1,"a",null,true,[2,[3]],{"k":{}}]`},
+		{`{"b": 1e-5, "a": [1e17]}`, `{"a":[1e+17],"b":1e-05}`},
+	}
+
+	jq := JSONOptions{JqCompatible: true}
+	for _, tt := range tests {
+		t.Run(tt.doc, func(t *testing.T) {
+			value, err := decodeJSON([]byte(tt.doc))
+			require.NoError(t, err)
+			actual, err := jq.encode(value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(actual))
+		})
+	}
+
+	t.Run("non-finite floats", func(t *testing.T) {
+		actual, err := jq.encode([]interface{}{math.NaN(), math.Inf(1), 1e17})
+		require.NoError(t, err)
+		assert.Equal(t, `[null,1.7976931348623157e+308,1e+17]`, string(actual))
+	})
+}
+
+func TestJSONOptions(t *testing.T) {
+	value := map[string]interface{}{
+		"name":  "café <b>",
+		"tags":  []interface{}{"a", 1e21},
+		"empty": []interface{}{},
+		"meta":  map[string]interface{}{"emoji": "😀", "none": map[string]interface{}{}},
+	}
+
+	tests := []struct {
+		name     string
+		opts     JSONOptions
+		expected string
+	}{
+		{"default", JSONOptions{},
+			`{"empty":[],"meta":{"emoji":"😀","none":{}},"name":"café \u003cb\u003e","tags":["a",1000000000000000000000]}`},
+		{"jq compatible", JSONOptions{JqCompatible: true},
+			`{"empty":[],"meta":{"emoji":"😀","none":{}},"name":"café <b>","tags":["a",1e+21]}`},
+		{"ascii", JSONOptions{JqCompatible: true, ASCII: true},
+			`{"empty":[],"meta":{"emoji":"\ud83d\ude00","none":{}},"name":"caf\u00e9 <b>","tags":["a",1e+21]}`},
+		{"indented", JSONOptions{JqCompatible: true, Indent: 2}, `{
+  "empty": [],
+  "meta": {
+    "emoji": "😀",
+    "none": {}
+  },
+  "name": "café <b>",
+  "tags": [
+    "a",
+    1e+21
+  ]
+}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.opts.encode(value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(encoded))
+		})
+	}
+
+	t.Run("indented output parses back", func(t *testing.T) {
+		encoded, err := JSONOptions{JqCompatible: true, Indent: 4}.encode(value)
+		require.NoError(t, err)
+		decoded, err := decodeJSON(encoded)
+		require.NoError(t, err)
+		assert.Equal(t, value, decoded)
+	})
+}
+
+func TestJSONBlock(t *testing.T) {
+	body := parseTestBody(t, `
+jq "pretty" {
+    query = "{b: .b, a: \"<a>\"}"
+
+    json {
+        jq_compatible = true
+        indent        = 2
+    }
+}
+
+jq "lines" {
+    output_format = "ndjson"
+    query         = ".[]"
+
+    json {
+        indent = 4
+        ascii  = true
+    }
+}
+`)
+	functions, _, diags := DecodeJqFunctions(body, "jq")
+	require.False(t, diags.HasErrors(), "Decoding should succeed: %s", diags)
+
+	result, err := functions["pretty"].Call([]cty.Value{cty.StringVal(`{"b": [1.5e-7]}`)})
+	require.NoError(t, err)
+	assert.Equal(t, cty.StringVal("{\n  \"a\": \"<a>\",\n  \"b\": [\n    1.5e-07\n  ]\n}"), result)
+
+	// NDJSON lines stay compact
+	result, err = functions["lines"].Call([]cty.Value{cty.StringVal(`[{"k": "é"}, [1]]`)})
+	require.NoError(t, err)
+	assert.Equal(t, cty.StringVal("{\"k\":\"\\u00e9\"}\n[1]\n"), result)
+
+	t.Run("run json", func(t *testing.T) {
+		fn, err := Compile("pretty", ".", nil, WithJSONOptions(JSONOptions{Indent: 1}))
+		require.NoError(t, err)
+		output, err := fn.RunJSON(context.Background(), []byte(`{"a":[1]}`))
+		require.NoError(t, err)
+		assert.Equal(t, "{\n \"a\": [\n  1\n ]\n}", string(output))
+
+		_, err = New("bad", ".", nil, WithJSONOptions(JSONOptions{Indent: 8}))
+		assert.EqualError(t, err, "jq function bad: json indent must be between 0 and 7, got 8")
+	})
+
+	t.Run("invalid blocks", func(t *testing.T) {
+		body := parseTestBody(t, `
+jq "too_wide" {
+    query = "."
+
+    json {
+        indent = 8
+    }
+}
+
+jq "fraction" {
+    query = "."
+
+    json {
+        indent = 1.5
+    }
+}
+
+jq "yaml_output" {
+    output_format = "yaml"
+    query         = "."
+
+    json {
+        ascii = true
+    }
+}
+`)
+		_, _, diags := DecodeJqFunctions(body, "jq")
+		require.Len(t, diags, 3)
+		assert.Equal(t, "indent must be a whole number of spaces from 0 to 7", diags[0].Detail)
+		assert.Equal(t, "indent must be a whole number of spaces from 0 to 7", diags[1].Detail)
+		assert.Equal(t, `json options require JSON output, not output_format = "yaml"`, diags[2].Detail)
+	})
+}
+
+func TestJSONDecodeErrors(t *testing.T) {
+	invalid := []string{
+		``, ` `, `nul`, `tru`, `[`, `[1,]`, `[1 2]`, `{`, `{"a"}`, `{"a":}`, `{"a":1,}`,
+		`{1:2}`, `"unterminated`, "\"ctrl\x01\"", `"\x"`, `"\u12"`, `"\u12g4"`,
+		`01`, `-`, `1.`, `.5`, `1e`, `1e+`, `+1`, `[1] [2]`, `1 2`, `NaN`, `'a'`,
+		strings.Repeat("[", maxJSONDepth+1) + strings.Repeat("]", maxJSONDepth+1),
+	}
+	for _, doc := range invalid {
+		t.Run(doc, func(t *testing.T) {
+			_, stdlibErr := stdlibDecodeJSON([]byte(doc))
+			require.Error(t, stdlibErr, "Reference decoder should reject the document")
+			_, err := decodeJSON([]byte(doc))
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("error offset", func(t *testing.T) {
+		_, err := decodeJSON([]byte(`{"a": [1, 2 3]}`))
+		require.Error(t, err)
+		assert.Equal(t, `invalid character '3' after array element at offset 12`, err.Error())
+	})
+}
+
+// largeJSONDocument builds an array of n records typical of API payloads
+func largeJSONDocument(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, `{"id":%d,"name":"item %d","price":%d.25,"tags":["a","b","c"],"active":%t,"meta":{"owner":"team-%d","note":null}}`,
+			i, i, i%100, i%2 == 0, i%7)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
+
+func BenchmarkJSON(b *testing.B) {
+	data := largeJSONDocument(1000)
+	value, err := decodeJSON(data)
+	require.NoError(b, err)
+
+	b.Run("decode/direct", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if _, err := decodeJSON(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("decode/stdlib", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if _, err := stdlibDecodeJSON(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("encode/direct", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if _, err := encodeJSON(value); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("encode/stdlib", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if _, err := stdlibEncodeJSON(value); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkCallJSON(b *testing.B) {
+	input := cty.StringVal(string(largeJSONDocument(1000)))
+	functions := map[string]string{
+		"identity": ".",
+		"filter":   "map(select(.active)) | map({id, name})",
+		"to_cty":   "map(.price)",
+	}
+
+	for name, query := range functions {
+		var opts []Option
+		if name == "to_cty" {
+			opts = append(opts, WithReturnType(cty.List(cty.Number)))
+		}
+		fn, err := New(name, query, nil, opts...)
+		require.NoError(b, err)
+
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := fn.Call([]cty.Value{input}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package jqfunc
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"unicode"
+	"unicode/utf8"
+)
+
+// jsonDialect selects the syntax jsonDecoder accepts
+type jsonDialect uint8
+
+const (
+	// dialectJSON is standard JSON (RFC 8259)
+	dialectJSON jsonDialect = iota
+
+	// dialectJSONC is JSON with comments and trailing commas, as in VS Code
+	// settings and tsconfig files
+	dialectJSONC
+
+	// dialectJSON5 is JSON5 (https://spec.json5.org): JSONC plus identifier
+	// and single-quoted keys, single-quoted strings with more escapes,
+	// hexadecimal numbers, Infinity and NaN, and more whitespace
+	dialectJSON5
+)
+
+// utf8BOM is the byte order mark, which JSONC and JSON5 documents may start
+// with
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// skipComments skips comments, along with the whitespace around them and,
+// in JSON5, its extra whitespace. It stops at a block comment that is never
+// closed, which syntaxError then reports.
+func (d *jsonDecoder) skipComments() {
+	for d.pos < len(d.data) {
+		c := d.data[d.pos]
+		switch {
+		case isJSONSpace(c):
+			d.pos++
+		case c == '/' && d.pos+1 < len(d.data) && d.data[d.pos+1] == '/':
+			for d.pos < len(d.data) && d.data[d.pos] != '\n' && d.data[d.pos] != '\r' {
+				d.pos++
+			}
+		case c == '/' && d.pos+1 < len(d.data) && d.data[d.pos+1] == '*':
+			end := bytes.Index(d.data[d.pos+2:], []byte("*/"))
+			if end < 0 {
+				return
+			}
+			d.pos += 2 + end + 2
+		case d.dialect == dialectJSON5:
+			size := json5SpaceSize(d.data[d.pos:])
+			if size == 0 {
+				return
+			}
+			d.pos += size
+		default:
+			return
+		}
+	}
+}
+
+// json5SpaceSize returns the length of the whitespace character JSON5 adds
+// to JSON's at the start of data, or 0 if there is none
+func json5SpaceSize(data []byte) int {
+	if c := data[0]; c < utf8.RuneSelf {
+		if c == '\v' || c == '\f' {
+			return 1
+		}
+		return 0
+	}
+	r, size := utf8.DecodeRune(data)
+	if r == '\u00a0' || r == '\ufeff' || r == '\u2028' || r == '\u2029' || unicode.Is(unicode.Zs, r) {
+		return size
+	}
+	return 0
+}
+
+// trailingComma reports whether the comma just read ends its object or
+// array, which JSONC and JSON5 allow, consuming the closing bracket if so
+func (d *jsonDecoder) trailingComma(closer byte) bool {
+	if d.dialect != dialectJSON && d.pos < len(d.data) && d.data[d.pos] == closer {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+// json5Value parses the values only JSON5 allows to start with the current
+// character
+func (d *jsonDecoder) json5Value() (interface{}, error) {
+	switch d.data[d.pos] {
+	case '\'':
+		return d.string()
+	case '+', '.', 'I', 'N':
+		return d.json5Number()
+	}
+	return nil, d.syntaxError("looking for beginning of value")
+}
+
+// json5Number parses a JSON5 number literal, which unlike JSON may have a
+// plus sign, a leading or trailing decimal point, or be Infinity, NaN or
+// hexadecimal. Decimal and hexadecimal integers are kept exact.
+func (d *jsonDecoder) json5Number() (interface{}, error) {
+	start := d.pos
+	negative := false
+	if c := d.data[d.pos]; c == '+' || c == '-' {
+		negative = c == '-'
+		d.pos++
+	}
+
+	rest := d.data[d.pos:]
+	switch {
+	case bytes.HasPrefix(rest, []byte("Infinity")):
+		d.pos += len("Infinity")
+		if negative {
+			return math.Inf(-1), nil
+		}
+		return math.Inf(1), nil
+	case bytes.HasPrefix(rest, []byte("NaN")):
+		d.pos += len("NaN")
+		return math.NaN(), nil
+	case len(rest) > 1 && rest[0] == '0' && (rest[1] == 'x' || rest[1] == 'X'):
+		d.pos += 2
+		return d.hexNumber(negative)
+	}
+
+	digits := d.pos
+	if d.pos < len(d.data) && d.data[d.pos] == '0' {
+		d.pos++ // no leading zeros, as in JSON
+	} else {
+		d.skipDigits()
+	}
+	integral := true
+	hasInteger := d.pos > digits
+	if d.pos < len(d.data) && d.data[d.pos] == '.' {
+		integral = false
+		d.pos++
+		if !d.skipDigits() && !hasInteger {
+			return nil, d.syntaxError("after decimal point in numeric literal")
+		}
+	} else if !hasInteger {
+		return nil, d.syntaxError("in numeric literal")
+	}
+	if exponent, err := d.exponent(); err != nil {
+		return nil, err
+	} else if exponent {
+		integral = false
+	}
+
+	text := d.data[start:d.pos]
+	if text[0] == '+' {
+		text = text[1:]
+	}
+	return d.numberValue(text, integral), nil
+}
+
+// hexNumber parses the digits of a hexadecimal integer literal
+func (d *jsonDecoder) hexNumber(negative bool) (interface{}, error) {
+	start := d.pos
+	for d.pos < len(d.data) {
+		if _, ok := unhex(d.data[d.pos]); !ok {
+			break
+		}
+		d.pos++
+	}
+	if d.pos == start {
+		return nil, d.syntaxError("in hexadecimal numeric literal")
+	}
+
+	n, _ := new(big.Int).SetString(string(d.data[start:d.pos]), 16)
+	if negative {
+		n.Neg(n)
+	}
+	if n.IsInt64() && math.MinInt <= n.Int64() && n.Int64() <= math.MaxInt {
+		return int(n.Int64()), nil
+	}
+	return n, nil
+}
+
+// json5Key parses an object key that is single-quoted or an ECMAScript
+// identifier name
+func (d *jsonDecoder) json5Key() (string, error) {
+	if d.pos < len(d.data) && d.data[d.pos] == '\'' {
+		return d.string()
+	}
+
+	var buf []byte
+	for d.pos < len(d.data) {
+		r, size := utf8.DecodeRune(d.data[d.pos:])
+		if r == '\\' && d.pos+1 < len(d.data) && d.data[d.pos+1] == 'u' {
+			// Identifiers may spell characters with \u escapes
+			start := d.pos
+			d.pos += 2
+			var err error
+			if r, err = d.hex4(); err != nil {
+				return "", err
+			}
+			if !isIdentifierRune(r, len(buf) == 0) {
+				d.pos = start
+				break
+			}
+			buf = utf8.AppendRune(buf, r)
+			continue
+		}
+		if !isIdentifierRune(r, len(buf) == 0) {
+			break
+		}
+		buf = utf8.AppendRune(buf, r)
+		d.pos += size
+	}
+	if len(buf) == 0 {
+		return "", d.syntaxError("looking for beginning of object key")
+	}
+	return string(buf), nil
+}
+
+// isIdentifierRune reports whether r can be part of an ECMAScript
+// identifier name, at its start if first is set
+func isIdentifierRune(r rune, first bool) bool {
+	switch {
+	case r == '$' || r == '_' || unicode.In(r, unicode.Lu, unicode.Ll, unicode.Lt, unicode.Lm, unicode.Lo, unicode.Nl):
+		return true
+	case first:
+		return false
+	default:
+		return r == '\u200c' || r == '\u200d' || unicode.In(r, unicode.Mn, unicode.Mc, unicode.Nd, unicode.Pc)
+	}
+}
+
+// json5Escape decodes the escape sequences JSON5 adds to JSON's, with the
+// current position just after the backslash. A backslash before a line
+// break continues the string on the next line, and one before any other
+// character without a meaning of its own stands for the character.
+func (d *jsonDecoder) json5Escape(buf []byte) ([]byte, error) {
+	switch c := d.data[d.pos]; {
+	case c == 'v':
+		d.pos++
+		return append(buf, '\v'), nil
+	case c == '0' && (d.pos+1 >= len(d.data) || d.data[d.pos+1] < '0' || d.data[d.pos+1] > '9'):
+		d.pos++
+		return append(buf, 0), nil
+	case '0' <= c && c <= '9':
+		return nil, d.syntaxError("in string escape code")
+	case c == 'x':
+		d.pos++
+		var r rune
+		for i := 0; i < 2; i++ {
+			if d.pos >= len(d.data) {
+				return nil, d.syntaxError("")
+			}
+			digit, ok := unhex(d.data[d.pos])
+			if !ok {
+				return nil, d.syntaxError("in \\x hexadecimal character escape")
+			}
+			r = r*16 + digit
+			d.pos++
+		}
+		return utf8.AppendRune(buf, r), nil
+	case c == '\n':
+		d.pos++
+		return buf, nil
+	case c == '\r':
+		d.pos++
+		if d.pos < len(d.data) && d.data[d.pos] == '\n' {
+			d.pos++
+		}
+		return buf, nil
+	}
+
+	r, size := utf8.DecodeRune(d.data[d.pos:])
+	d.pos += size
+	if r == '\u2028' || r == '\u2029' {
+		return buf, nil
+	}
+	return utf8.AppendRune(buf, r), nil
+}
@@ -0,0 +1,157 @@
+package jqfunc
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestDecodeJSONC(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected interface{}
+	}{
+		{"line comments", "// settings\n{\n  \"a\": 1, // one\n  \"b\": 2\n}\n// end",
+			map[string]interface{}{"a": 1, "b": 2}},
+		{"block comments", "/* header\n * more */ [1, /* inline */ 2 /**/]",
+			[]interface{}{1, 2}},
+		{"trailing commas", "{\"a\": [1, 2,], \"b\": {\"c\": true,},}",
+			map[string]interface{}{"a": []interface{}{1, 2}, "b": map[string]interface{}{"c": true}}},
+		{"comment markers in strings", "{\"url\": \"http://x/*y*/\"}",
+			map[string]interface{}{"url": "http://x/*y*/"}},
+		{"byte order mark", "\xef\xbb\xbf{}", map[string]interface{}{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := decodeDocument([]byte(tt.data), FormatJSONC)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, v)
+		})
+	}
+
+	t.Run("errors give offsets in the original text", func(t *testing.T) {
+		errorTests := []struct {
+			name     string
+			data     string
+			expected string
+		}{
+			{"after a comment", "{\n  // the port\n  \"port\": 80 80\n}", `invalid character '8' after object key:value pair at offset 29`},
+			{"unterminated comment", "[1, /* 2 ]", "unterminated comment at offset 4"},
+			{"lone slash", "[1 / 2]", `invalid character '/' after array element at offset 3`},
+			{"empty with a comma", "[,]", `invalid character ',' looking for beginning of value at offset 1`},
+			{"two trailing commas", "[1,,]", `invalid character ',' looking for beginning of value at offset 3`},
+			{"JSON5 syntax", "{a: 1}", `invalid character 'a' looking for beginning of object key string at offset 1`},
+		}
+		for _, tt := range errorTests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := decodeDocument([]byte(tt.data), FormatJSONC)
+				require.Error(t, err)
+				assert.Equal(t, tt.expected, err.Error())
+			})
+		}
+	})
+
+	t.Run("JSON stays strict", func(t *testing.T) {
+		_, err := decodeJSON([]byte("[1, // one\n]"))
+		assert.EqualError(t, err, `invalid character '/' looking for beginning of value at offset 4`)
+		_, err = decodeJSON([]byte("[1,]"))
+		assert.EqualError(t, err, `invalid character ']' looking for beginning of value at offset 3`)
+	})
+}
+
+func TestDecodeJSON5(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected interface{}
+	}{
+		{"keys", "{unquoted: 1, $dollar_1: 2, 'single': 3, \"double\": 4, café: 5, \\u0061b: 6}",
+			map[string]interface{}{"unquoted": 1, "$dollar_1": 2, "single": 3, "double": 4, "café": 5, "ab": 6}},
+		{"strings", `['it\'s', "say 'hi'", '\x41\v\0', 'line \` + "\n" + `continued', '\q', "tab	inside"]`,
+			[]interface{}{"it's", "say 'hi'", "A\v\x00", "line continued", "q", "tab\tinside"}},
+		{"numbers", "[+1, -2, .5, 5., -.5e1, 0x1F, -0XfF, 0x123456789abcdef0123, 123456789012345678901234567890]",
+			[]interface{}{1, -2, 0.5, 5.0, -5.0, 31, -255, mustBigInt("5373003642731685151011"), mustBigInt("123456789012345678901234567890")}},
+		{"infinity", "[Infinity, +Infinity, -Infinity]", []interface{}{math.Inf(1), math.Inf(1), math.Inf(-1)}},
+		{"whitespace", "\v\f\u00a0\ufeff\u2028{ a : 1 }", map[string]interface{}{"a": 1}},
+		{"comments and trailing commas", "{\n  // note\n  list: [1, 2,],\n  /* done */\n}",
+			map[string]interface{}{"list": []interface{}{1, 2}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := decodeDocument([]byte(tt.data), FormatJSON5)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, v)
+		})
+	}
+
+	t.Run("NaN", func(t *testing.T) {
+		v, err := decodeDocument([]byte("-NaN"), FormatJSON5)
+		require.NoError(t, err)
+		assert.True(t, math.IsNaN(v.(float64)))
+	})
+
+	t.Run("errors give offsets in the original text", func(t *testing.T) {
+		errorTests := []struct {
+			name     string
+			data     string
+			expected string
+		}{
+			{"leading zero", "[01]", `invalid character '1' after array element at offset 2`},
+			{"bare point", "[.]", `invalid character ']' after decimal point in numeric literal at offset 2`},
+			{"empty hex", "0x", "unexpected end of JSON input"},
+			{"octal escape", `'\1'`, `invalid character '1' in string escape code at offset 2`},
+			{"bad hex escape", `'\xZ0'`, `invalid character 'Z' in \x hexadecimal character escape at offset 3`},
+			{"raw line break", "'a\nb'", `invalid character '\n' in string literal at offset 2`},
+			{"key starting with a digit", "{1a: 1}", `invalid character '1' looking for beginning of object key at offset 1`},
+			{"unknown literal", "[undefined]", `invalid character 'u' looking for beginning of value at offset 1`},
+		}
+		for _, tt := range errorTests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := decodeDocument([]byte(tt.data), FormatJSON5)
+				require.Error(t, err)
+				assert.Equal(t, tt.expected, err.Error())
+			})
+		}
+	})
+}
+
+func TestJSONCFormats(t *testing.T) {
+	body := parseTestBody(t, `
+jq "compiler_target" {
+    input_format = "jsonc"
+    query        = ".compilerOptions.target"
+}
+
+jq "version" {
+    input_format = "json5"
+    query        = ".version"
+}
+`)
+	functions, _, diags := DecodeJqFunctions(body, "jq")
+	require.False(t, diags.HasErrors(), "Decoding should succeed: %s", diags)
+
+	tsconfig := cty.StringVal(`{
+  // Compiler settings
+  "compilerOptions": {
+    "target": "es2022", /* the oldest we support */
+    "strict": true,
+  },
+}`)
+	result, err := functions["compiler_target"].Call([]cty.Value{tsconfig})
+	require.NoError(t, err)
+	assert.Equal(t, cty.StringVal("es2022"), result)
+
+	result, err = functions["version"].Call([]cty.Value{cty.StringVal("{version: 0x10, name: 'app'}")})
+	require.NoError(t, err)
+	assert.Equal(t, cty.StringVal(`16`), result)
+
+	_, err = functions["compiler_target"].Call([]cty.Value{cty.StringVal("{\n  // no value\n  \"target\": ,\n}")})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid JSONC input: invalid character ',' looking for beginning of value at offset 28`)
+}
@@ -0,0 +1,69 @@
+package jqfunc
+
+import (
+	"sort"
+	"strconv"
+)
+
+// keyOrder records the order of object keys in JSON input, since gojq
+// objects are maps and lose it, so that output can follow it where it can.
+// An output object with the same keys as an input object gets the order of
+// that object. Otherwise its keys follow the order in which they first
+// appear in the input, and keys that never appear there come last, sorted.
+type keyOrder struct {
+	// objects maps the key sets of input objects to their keys in order,
+	// for the first object with each set
+	objects map[string][]string
+
+	// rank gives each key the position of its first appearance
+	rank map[string]int
+}
+
+func newKeyOrder() *keyOrder {
+	return &keyOrder{
+		objects: make(map[string][]string),
+		rank:    make(map[string]int),
+	}
+}
+
+// see records a key as it is read
+func (o *keyOrder) see(key string) {
+	if _, ok := o.rank[key]; !ok {
+		o.rank[key] = len(o.rank)
+	}
+}
+
+// add records the keys of an input object in order, without duplicates
+func (o *keyOrder) add(keys []string) {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	set := keySet(sorted)
+	if _, ok := o.objects[set]; !ok {
+		o.objects[set] = keys
+	}
+}
+
+// arrange orders the sorted keys of an output object, reusing the slice
+func (o *keyOrder) arrange(keys []string) []string {
+	if ordered, ok := o.objects[keySet(keys)]; ok {
+		return ordered
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		ri, iok := o.rank[keys[i]]
+		rj, jok := o.rank[keys[j]]
+		if iok && jok {
+			return ri < rj
+		}
+		return iok && !jok
+	})
+	return keys
+}
+
+// keySet identifies a set of keys given in sorted order
+func keySet(sorted []string) string {
+	var buf []byte
+	for _, key := range sorted {
+		buf = strconv.AppendQuote(buf, key)
+	}
+	return string(buf)
+}
@@ -0,0 +1,151 @@
+package jqfunc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestKeyOrder(t *testing.T) {
+	order := newKeyOrder()
+	_, err := newJSONDecoder([]byte(`{"z": 1, "a": {"y": 2, "b": 3}, "m": [{"k": 1, "j": 2}], "z": 4}`), dialectJSON, order).document()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		keys     []string
+		expected []string
+	}{
+		{"same keys as an input object", []string{"a", "m", "z"}, []string{"z", "a", "m"}},
+		{"nested object", []string{"b", "y"}, []string{"y", "b"}},
+		{"subset", []string{"a", "z"}, []string{"z", "a"}},
+		{"new keys come last, sorted", []string{"a", "another", "k", "new", "z"}, []string{"z", "a", "k", "another", "new"}},
+		{"only new keys", []string{"c", "d"}, []string{"c", "d"}},
+		{"keys that look like a joined set", []string{"j\"\"k"}, []string{"j\"\"k"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, order.arrange(append([]string(nil), tt.keys...)))
+		})
+	}
+}
+
+func TestPreserveOrder(t *testing.T) {
+	body := parseTestBody(t, `
+jq "bump" {
+    query = ".version = \"1.1.0\""
+
+    json {
+        preserve_order = true
+        indent         = 2
+    }
+}
+
+jq "reshape" {
+    query = "del(.private) | .license = \"MIT\""
+
+    json {
+        preserve_order = true
+    }
+}
+
+jq "sorted" {
+    query = "."
+}
+
+jq "lines" {
+    input_format  = "ndjson"
+    output_format = "ndjson"
+    query         = ".seen = true"
+
+    json {
+        preserve_order = true
+    }
+}
+`)
+	functions, _, diags := DecodeJqFunctions(body, "jq")
+	require.False(t, diags.HasErrors(), "Decoding should succeed: %s", diags)
+
+	manifest := `{
+  "name": "app",
+  "version": "1.0.0",
+  "private": true,
+  "scripts": {
+    "test": "jest",
+    "build": "tsc"
+  },
+  "dependencies": {
+    "zod": "^3.0.0",
+    "axios": "^1.0.0"
+  }
+}`
+
+	tests := []struct {
+		name     string
+		function string
+		input    string
+		expected string
+	}{
+		{"unchanged keys keep their order", "bump", manifest,
+			`{
+  "name": "app",
+  "version": "1.1.0",
+  "private": true,
+  "scripts": {
+    "test": "jest",
+    "build": "tsc"
+  },
+  "dependencies": {
+    "zod": "^3.0.0",
+    "axios": "^1.0.0"
+  }
+}`},
+		{"removed and added keys", "reshape", manifest,
+			`{"name":"app","version":"1.0.0","scripts":{"test":"jest","build":"tsc"},"dependencies":{"zod":"^3.0.0","axios":"^1.0.0"},"license":"MIT"}`},
+		{"sorted by default", "sorted", `{"b": 1, "a": {"d": 2, "c": 3}}`, `{"a":{"c":3,"d":2},"b":1}`},
+		{"ndjson", "lines", "{\"z\": 1, \"a\": 2}\n{\"y\": 3}\n", "{\"z\":1,\"a\":2,\"seen\":true}\n{\"y\":3,\"seen\":true}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := functions[tt.function].Call([]cty.Value{cty.StringVal(tt.input)})
+			require.NoError(t, err)
+			assert.Equal(t, cty.StringVal(tt.expected), result)
+		})
+	}
+
+	t.Run("calls keep their own order", func(t *testing.T) {
+		runParallel(t, func(worker, iteration int) {
+			input, expected := `{"b": 1, "a": 2}`, `{"b":1,"a":2,"license":"MIT"}`
+			if (worker+iteration)%2 == 0 {
+				input, expected = `{"a": 2, "b": 1}`, `{"a":2,"b":1,"license":"MIT"}`
+			}
+			result, err := functions["reshape"].Call([]cty.Value{cty.StringVal(input)})
+			require.NoError(t, err)
+			assert.Equal(t, cty.StringVal(expected), result)
+		})
+	})
+
+	t.Run("run json and iter", func(t *testing.T) {
+		fn, err := Compile("keys", ".[]", nil, WithJSONOptions(JSONOptions{PreserveOrder: true}))
+		require.NoError(t, err)
+
+		output, err := fn.RunJSON(context.Background(), []byte(`[{"b": 1, "a": 2}]`))
+		require.NoError(t, err)
+		assert.Equal(t, `{"b":1,"a":2}`, string(output))
+
+		for value, err := range fn.Iter(context.Background(), cty.StringVal(`[{"y": 1, "x": 2}]`)) {
+			require.NoError(t, err)
+			assert.Equal(t, cty.StringVal(`{"y":1,"x":2}`), value)
+		}
+	})
+
+	t.Run("needs json input", func(t *testing.T) {
+		_, err := New("bad", ".", nil, WithInputFormat(FormatYAML), WithJSONOptions(JSONOptions{PreserveOrder: true}))
+		assert.EqualError(t, err, `jq function bad: preserve_order requires JSON input, not input_format = "yaml"`)
+	})
+}
@@ -0,0 +1,149 @@
+package jqfunc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// Library holds a set of compiled jq functions together with their metadata,
+// in the order they were defined. The zero value is an empty library.
+//
+// Lookups and the functions themselves may be used concurrently, but Add and
+// Merge must not run concurrently with any other use of the library.
+type Library struct {
+	funcs  []*JqFunction
+	byName map[string]int
+}
+
+// NewLibrary creates a library from compiled functions. It fails if two of
+// them have the same name.
+func NewLibrary(funcs ...*JqFunction) (*Library, error) {
+	lib := &Library{}
+	for _, fn := range funcs {
+		if err := lib.Add(fn); err != nil {
+			return nil, err
+		}
+	}
+	return lib, nil
+}
+
+// DecodeLibrary is DecodeJqFunctionsWithOptions returning a Library instead
+// of a function map
+func DecodeLibrary(body hcl.Body, opts ...Option) (*Library, hcl.Body, hcl.Diagnostics) {
+	jqFuncs, remainingBody, diags := decodeJqFunctions(body, newConfig(opts))
+	if jqFuncs == nil {
+		return nil, remainingBody, diags
+	}
+
+	// Names are already unique after the duplicate policy was applied
+	lib := &Library{}
+	for _, jqFunc := range jqFuncs {
+		lib.add(jqFunc)
+	}
+	return lib, remainingBody, diags
+}
+
+// Len returns the number of functions in the library
+func (lib *Library) Len() int {
+	return len(lib.funcs)
+}
+
+// Lookup returns the function with the given name
+func (lib *Library) Lookup(name string) (*JqFunction, bool) {
+	i, ok := lib.byName[name]
+	if !ok {
+		return nil, false
+	}
+	return lib.funcs[i], true
+}
+
+// Names returns the function names in definition order
+func (lib *Library) Names() []string {
+	names := make([]string, len(lib.funcs))
+	for i, fn := range lib.funcs {
+		names[i] = fn.Name
+	}
+	return names
+}
+
+// Functions returns the functions in definition order. The slice is a copy,
+// but the functions are shared with the library.
+func (lib *Library) Functions() []*JqFunction {
+	return append([]*JqFunction(nil), lib.funcs...)
+}
+
+// Add appends a function to the library. It fails if the library already has
+// a function with the same name.
+func (lib *Library) Add(fn *JqFunction) error {
+	if existing, ok := lib.Lookup(fn.Name); ok {
+		return collisionError([]*JqFunction{existing})
+	}
+	lib.add(fn)
+	return nil
+}
+
+func (lib *Library) add(fn *JqFunction) {
+	if lib.byName == nil {
+		lib.byName = make(map[string]int)
+	}
+	lib.byName[fn.Name] = len(lib.funcs)
+	lib.funcs = append(lib.funcs, fn)
+}
+
+// Merge appends the functions of other, after those already in the library.
+// If any name is defined in both libraries nothing is merged and the error
+// names every collision.
+func (lib *Library) Merge(other *Library) error {
+	var collisions []*JqFunction
+	for _, fn := range other.funcs {
+		if existing, ok := lib.Lookup(fn.Name); ok {
+			collisions = append(collisions, existing)
+		}
+	}
+	if len(collisions) > 0 {
+		return collisionError(collisions)
+	}
+
+	for _, fn := range other.funcs {
+		lib.add(fn)
+	}
+	return nil
+}
+
+// FunctionMap returns the HCL functions by name, like DecodeJqFunctions
+func (lib *Library) FunctionMap() map[string]function.Function {
+	functions := make(map[string]function.Function, len(lib.funcs))
+	for _, fn := range lib.funcs {
+		functions[fn.Name] = fn.Function()
+	}
+	return functions
+}
+
+// EvalContext returns a new root EvalContext with the library's functions
+func (lib *Library) EvalContext() *hcl.EvalContext {
+	return &hcl.EvalContext{Functions: lib.FunctionMap()}
+}
+
+// ChildContext returns a child of parent with the library's functions. Names
+// in the library shadow functions of the same name in parent.
+func (lib *Library) ChildContext(parent *hcl.EvalContext) *hcl.EvalContext {
+	ctx := parent.NewChild()
+	ctx.Functions = lib.FunctionMap()
+	return ctx
+}
+
+// collisionError reports functions that are already defined
+func collisionError(existing []*JqFunction) error {
+	descriptions := make([]string, len(existing))
+	for i, fn := range existing {
+		if fn.Range.Filename != "" {
+			descriptions[i] = fmt.Sprintf("%s (defined at %s)", fn.Name, fn.Range)
+		} else {
+			descriptions[i] = fn.Name
+		}
+	}
+	return fmt.Errorf("jq functions already defined: %s", strings.Join(descriptions, ", "))
+}
@@ -0,0 +1,113 @@
+package jqfunc
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+)
+
+func TestLibrary(t *testing.T) {
+	body := parseTestBody(t, `
+jq "names" {
+    query = "[.[].name]"
+}
+
+jq "count" {
+    params = [field]
+    query = "[.[][$field]] | length"
+}
+`)
+	lib, _, diags := DecodeLibrary(body)
+	require.False(t, diags.HasErrors(), "Library decoding should succeed: %s", diags)
+
+	t.Run("lookup keeps metadata", func(t *testing.T) {
+		fn, ok := lib.Lookup("count")
+		require.True(t, ok)
+		assert.Equal(t, []string{"field"}, fn.Params)
+		assert.Equal(t, "[.[][$field]] | length", fn.Query)
+		assert.Equal(t, "decode.hcl", fn.Range.Filename)
+
+		_, ok = lib.Lookup("missing")
+		assert.False(t, ok)
+	})
+
+	t.Run("definition order", func(t *testing.T) {
+		assert.Equal(t, 2, lib.Len())
+		assert.Equal(t, []string{"names", "count"}, lib.Names())
+
+		funcs := lib.Functions()
+		require.Len(t, funcs, 2)
+		assert.Equal(t, "names", funcs[0].Name)
+		assert.Equal(t, "count", funcs[1].Name)
+	})
+
+	t.Run("eval context", func(t *testing.T) {
+		expr, parseDiags := hclsyntax.ParseExpression([]byte(`names("[{\"name\": \"a\"}]")`), "expr.hcl", hcl.InitialPos)
+		require.False(t, parseDiags.HasErrors())
+
+		result, valDiags := expr.Value(lib.EvalContext())
+		require.False(t, valDiags.HasErrors(), "Evaluation should succeed: %s", valDiags)
+		assert.Equal(t, `["a"]`, result.AsString())
+	})
+
+	t.Run("child context", func(t *testing.T) {
+		parent := &hcl.EvalContext{
+			Functions: map[string]function.Function{"upper": stdlib.UpperFunc},
+		}
+		expr, parseDiags := hclsyntax.ParseExpression([]byte(`upper(names("[{\"name\": \"a\"}]"))`), "expr.hcl", hcl.InitialPos)
+		require.False(t, parseDiags.HasErrors())
+
+		result, valDiags := expr.Value(lib.ChildContext(parent))
+		require.False(t, valDiags.HasErrors(), "Evaluation should succeed: %s", valDiags)
+		assert.Equal(t, `["A"]`, result.AsString())
+		assert.NotContains(t, parent.Functions, "names")
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		other, err := NewLibrary(mustCompile(t, "first", ".[0]"))
+		require.NoError(t, err)
+
+		merged, err := NewLibrary(lib.Functions()...)
+		require.NoError(t, err)
+		require.NoError(t, merged.Merge(other))
+		assert.Equal(t, []string{"names", "count", "first"}, merged.Names())
+		assert.Equal(t, 2, lib.Len(), "Source library should be unchanged")
+
+		result, err := merged.FunctionMap()["first"].Call([]cty.Value{cty.StringVal(`[7]`)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, "7", result.AsString())
+	})
+
+	t.Run("merge collisions", func(t *testing.T) {
+		other, err := NewLibrary(mustCompile(t, "extra", "."), mustCompile(t, "names", "."))
+		require.NoError(t, err)
+
+		merged, err := NewLibrary(lib.Functions()...)
+		require.NoError(t, err)
+		err = merged.Merge(other)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "names (defined at decode.hcl:2,1-11)")
+		assert.Equal(t, []string{"names", "count"}, merged.Names(), "Nothing should be merged")
+	})
+
+	t.Run("add collision", func(t *testing.T) {
+		var empty Library
+		require.NoError(t, empty.Add(mustCompile(t, "f", ".")))
+		err := empty.Add(mustCompile(t, "f", "."))
+		require.Error(t, err)
+		assert.Equal(t, "jq functions already defined: f", err.Error())
+	})
+}
+
+func mustCompile(t *testing.T, name, query string) *JqFunction {
+	t.Helper()
+	fn, err := Compile(name, query, nil)
+	require.NoError(t, err)
+	return fn
+}
@@ -0,0 +1,130 @@
+package jqfunc
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestMarkedValues(t *testing.T) {
+	hclCode := `
+jqfunction "get_password" {
+    params = []
+    query = ".password"
+}
+
+jqfunction "with_suffix" {
+    params = [suffix]
+    query = ".name + $suffix"
+}
+
+jqfunction "secret_name" {
+    params = []
+    query = ".name"
+    sensitive = true
+}
+`
+	parser := hclparse.NewParser()
+	file, diags := parser.ParseHCL([]byte(hclCode), "marks.hcl")
+	require.False(t, diags.HasErrors(), "HCL parsing should succeed: %s", diags)
+
+	functions, _, diags := DecodeJqFunctions(file.Body, "jqfunction")
+	require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+
+	t.Run("nested mark is propagated to result", func(t *testing.T) {
+		input := cty.ObjectVal(map[string]cty.Value{
+			"user":     cty.StringVal("alice"),
+			"password": cty.StringVal("hunter2").Mark("sensitive"),
+		})
+
+		result, err := functions["get_password"].Call([]cty.Value{input})
+		require.NoError(t, err, "Function call should succeed with nested marks")
+		assert.True(t, result.HasMark("sensitive"), "Result should carry the input mark")
+
+		unmarked, _ := result.Unmark()
+		assert.Equal(t, "hunter2", unmarked.AsString())
+	})
+
+	t.Run("top-level mark on string input", func(t *testing.T) {
+		input := cty.StringVal(`{"password": "hunter2"}`).Mark("sensitive")
+
+		result, err := functions["get_password"].Call([]cty.Value{input})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.HasMark("sensitive"), "Result should carry the input mark")
+	})
+
+	t.Run("union of input and argument marks", func(t *testing.T) {
+		input := cty.ObjectVal(map[string]cty.Value{
+			"name": cty.StringVal("alice").Mark("a"),
+		})
+		suffix := cty.StringVal("@example.com").Mark("b")
+
+		result, err := functions["with_suffix"].Call([]cty.Value{input, suffix})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.HasMark("a"), "Result should carry the input mark")
+		assert.True(t, result.HasMark("b"), "Result should carry the argument mark")
+
+		unmarked, _ := result.Unmark()
+		assert.Equal(t, "alice@example.com", unmarked.AsString())
+	})
+
+	t.Run("unmarked input stays unmarked", func(t *testing.T) {
+		input := cty.ObjectVal(map[string]cty.Value{
+			"password": cty.StringVal("hunter2"),
+		})
+
+		result, err := functions["get_password"].Call([]cty.Value{input})
+		require.NoError(t, err, "Function call should succeed")
+		assert.False(t, result.IsMarked(), "Result should not be marked")
+	})
+
+	t.Run("sensitive block marks output", func(t *testing.T) {
+		input := cty.ObjectVal(map[string]cty.Value{
+			"name": cty.StringVal("alice"),
+		})
+
+		result, err := functions["secret_name"].Call([]cty.Value{input})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.HasMark(SensitiveMark), "Result should be marked sensitive")
+	})
+
+	t.Run("marked value is returned by HCL expressions", func(t *testing.T) {
+		expr, diags := parser.ParseHCL([]byte(`out = get_password(creds)`), "expr.hcl")
+		require.False(t, diags.HasErrors(), "Expression parsing should succeed: %s", diags)
+		attrs, diags := expr.Body.JustAttributes()
+		require.False(t, diags.HasErrors(), "Attributes should decode: %s", diags)
+
+		ctx := &hcl.EvalContext{
+			Functions: functions,
+			Variables: map[string]cty.Value{
+				"creds": cty.ObjectVal(map[string]cty.Value{
+					"password": cty.StringVal("hunter2").Mark("sensitive"),
+				}),
+			},
+		}
+		val, diags := attrs["out"].Expr.Value(ctx)
+		require.False(t, diags.HasErrors(), "Evaluation should succeed: %s", diags)
+		assert.True(t, val.HasMark("sensitive"), "Evaluated value should be marked")
+	})
+}
+
+func TestSensitiveAttributeErrors(t *testing.T) {
+	hclCode := `
+jqfunction "bad" {
+    query = "."
+    sensitive = "yes"
+}
+`
+	parser := hclparse.NewParser()
+	file, diags := parser.ParseHCL([]byte(hclCode), "marks.hcl")
+	require.False(t, diags.HasErrors(), "HCL parsing should succeed: %s", diags)
+
+	functions, _, diags := DecodeJqFunctions(file.Body, "jqfunction")
+	require.True(t, diags.HasErrors(), "Non-boolean sensitive should be rejected")
+	assert.Contains(t, diags.Error(), "Invalid sensitive value")
+	assert.Empty(t, functions, "No functions should be created")
+}
@@ -0,0 +1,200 @@
+package jqfunc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// decodeJSONStream parses a sequence of documents as NDJSON string input is
+// parsed
+func decodeJSONStream(data []byte) ([]interface{}, error) {
+	jqFunc := &JqFunction{InputFormat: FormatNDJSON}
+	return jqFunc.decodeInput(data, jqFunc.converter())
+}
+
+func TestDecodeJSONStream(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected []interface{}
+	}{
+		{"lines", "{\"a\":1}\n{\"a\":2}\n", []interface{}{map[string]interface{}{"a": 1}, map[string]interface{}{"a": 2}}},
+		{"blank lines and CRLF", "\r\n1\r\n\r\n  2  \r\n", []interface{}{1, 2}},
+		{"no final newline", "[1]\n\"x\"", []interface{}{[]interface{}{1}, "x"}},
+		{"JSON text sequence", "\x1e{\"a\":1}\n\x1e[2]\n", []interface{}{map[string]interface{}{"a": 1}, []interface{}{2}}},
+		{"several values on a line", "1 2 [3]", []interface{}{1, 2, []interface{}{3}}},
+		{"big integers", "123456789012345678901234567890\n", []interface{}{mustBigInt("123456789012345678901234567890")}},
+		{"empty", "", nil},
+		{"whitespace only", "\n\n \x1e\n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := decodeJSONStream([]byte(tt.data))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, values)
+		})
+	}
+
+	t.Run("errors give the line", func(t *testing.T) {
+		_, err := decodeJSONStream([]byte("{\"a\":1}\n{\"a\":2}\n{\"a\":}\n"))
+		require.Error(t, err)
+		assert.Equal(t, `line 3: invalid character '}' looking for beginning of value at offset 21`, err.Error())
+
+		_, err = decodeJSONStream([]byte("1\n[2,\n"))
+		require.Error(t, err)
+		assert.Equal(t, `line 3: unexpected end of JSON input`, err.Error())
+	})
+}
+
+func TestNDJSONFormat(t *testing.T) {
+	body := parseTestBody(t, `
+jq "levels" {
+    input_format = "ndjson"
+    query        = ".level"
+}
+
+jq "pairs" {
+    input_format = "ndjson"
+    query        = "[., input]"
+}
+
+jq "first_and_rest" {
+    input_format = "ndjson"
+    query        = "{first: .n, rest: [inputs | .n]}"
+}
+
+jq "total" {
+    input_format = "ndjson"
+    null_input   = true
+    query        = "reduce inputs as $line (0; . + $line.n)"
+}
+
+jq "next" {
+    input_format = "ndjson"
+    null_input   = true
+    query        = "input"
+}
+
+jq "errors" {
+    input_format  = "ndjson"
+    output_format = "ndjson"
+    query         = "select(.level == \"error\") | {msg}"
+}
+
+jq "lines" {
+    output_format = "ndjson"
+    query         = ".[]"
+}
+`)
+	functions, _, diags := DecodeJqFunctions(body, "jq")
+	require.False(t, diags.HasErrors(), "Decoding should succeed: %s", diags)
+
+	logs := cty.StringVal(strings.Join([]string{
+		`{"level": "info", "msg": "starting", "n": 1}`,
+		`{"level": "error", "msg": "disk full", "n": 2}`,
+		`{"level": "info", "msg": "retrying", "n": 3}`,
+		`{"level": "error", "msg": "gave up", "n": 4}`,
+	}, "\n") + "\n")
+
+	tests := []struct {
+		name     string
+		function string
+		input    cty.Value
+		expected cty.Value
+	}{
+		{"query runs on each line", "levels", logs, cty.StringVal(`["info","error","info","error"]`)},
+		{"input reads the next line", "pairs", cty.StringVal("1\n2\n3\n4\n"), cty.StringVal(`[[1,2],[3,4]]`)},
+		{"inputs reads the rest", "first_and_rest", logs, cty.StringVal(`{"first":1,"rest":[2,3,4]}`)},
+		{"null input", "total", logs, cty.StringVal(`10`)},
+		{"null input without lines", "total", cty.StringVal(""), cty.StringVal(`0`)},
+		{"ndjson output", "errors", logs, cty.StringVal("{\"msg\":\"disk full\"}\n{\"msg\":\"gave up\"}\n")},
+		{"no lines", "errors", cty.StringVal(""), cty.StringVal("")},
+		{"cty input", "lines", cty.TupleVal([]cty.Value{cty.NumberIntVal(1), cty.StringVal("a"), cty.EmptyObjectVal}), cty.StringVal("1\n\"a\"\n{}\n")},
+		{"cty input is the first document", "first_and_rest", cty.ObjectVal(map[string]cty.Value{"n": cty.NumberIntVal(5)}),
+			cty.ObjectVal(map[string]cty.Value{"first": cty.NumberIntVal(5), "rest": cty.EmptyTupleVal})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := functions[tt.function].Call([]cty.Value{tt.input})
+			require.NoError(t, err)
+			assert.True(t, result.RawEquals(tt.expected), "Expected %#v, got %#v", tt.expected, result)
+		})
+	}
+
+	t.Run("no more inputs", func(t *testing.T) {
+		_, err := functions["pairs"].Call([]cty.Value{cty.StringVal("1\n2\n3\n")})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "jq execution error")
+	})
+
+	t.Run("unread inputs do not carry over", func(t *testing.T) {
+		for _, line := range []string{"1", "2", "3"} {
+			result, err := functions["next"].Call([]cty.Value{cty.StringVal(line + "\n9\n")})
+			require.NoError(t, err)
+			assert.Equal(t, cty.StringVal(line), result)
+		}
+	})
+
+	t.Run("invalid input gives the line", func(t *testing.T) {
+		_, err := functions["levels"].Call([]cty.Value{cty.StringVal("{}\n{\"level\": \n")})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid NDJSON input: line 3: unexpected end of JSON input")
+	})
+
+	t.Run("iter", func(t *testing.T) {
+		fn, err := Compile("levels", ".level", nil, WithInputFormat(FormatNDJSON))
+		require.NoError(t, err)
+
+		var levels []string
+		for value, err := range fn.Iter(context.Background(), logs) {
+			require.NoError(t, err)
+			levels = append(levels, value.AsString())
+			if len(levels) == 2 {
+				break
+			}
+		}
+		assert.Equal(t, []string{"info", "error"}, levels)
+	})
+
+	t.Run("concurrent calls read their own inputs", func(t *testing.T) {
+		runParallel(t, func(worker, iteration int) {
+			input := fmt.Sprintf("{\"n\": %d}\n{\"n\": %d}\n", worker, iteration)
+			result, err := functions["total"].Call([]cty.Value{cty.StringVal(input)})
+			require.NoError(t, err)
+			assert.Equal(t, cty.StringVal(fmt.Sprint(worker+iteration)), result)
+		})
+	})
+
+	t.Run("input needs a format that feeds inputs", func(t *testing.T) {
+		_, err := Compile("pairs", "[., input]", nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "input(s)/0 is not allowed")
+
+		// The cache keeps the two compilations apart
+		cache := NewCompileCache(0)
+		_, err = Compile("pairs", "[., input]", nil, WithInputFormat(FormatNDJSON), WithCompileCache(cache))
+		require.NoError(t, err)
+		_, err = Compile("pairs", "[., input]", nil, WithCompileCache(cache))
+		require.Error(t, err)
+	})
+
+	t.Run("null input needs ndjson", func(t *testing.T) {
+		body := parseTestBody(t, `
+jq "total" {
+    null_input = true
+    query      = "[inputs]"
+}
+`)
+		_, _, diags := DecodeJqFunctions(body, "jq")
+		require.True(t, diags.HasErrors())
+		assert.Equal(t, `null_input requires input_format = "ndjson"`, diags[0].Detail)
+	})
+}
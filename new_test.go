@@ -0,0 +1,106 @@
+package jqfunc
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("function without HCL source", func(t *testing.T) {
+		addTax, err := New("add_tax", ".price * (1 + $rate)", []string{"rate"})
+		require.NoError(t, err, "New should succeed")
+
+		result, err := addTax.Call([]cty.Value{
+			cty.StringVal(`{"price": 100}`),
+			cty.NumberFloatVal(0.08),
+		})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, "108", result.AsString())
+	})
+
+	t.Run("options match block attributes", func(t *testing.T) {
+		names, err := New("names", "[.[].name]", nil,
+			WithReturnType(cty.List(cty.String)),
+			WithSensitive(true),
+		)
+		require.NoError(t, err, "New should succeed")
+
+		ty, err := names.ReturnType([]cty.Type{cty.String})
+		require.NoError(t, err)
+		assert.Equal(t, cty.List(cty.String), ty)
+
+		result, err := names.Call([]cty.Value{cty.StringVal(`[{"name": "a"}, {"name": "b"}]`)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.HasMark(SensitiveMark), "Result should be marked sensitive")
+
+		unmarked, _ := result.Unmark()
+		assert.True(t, unmarked.RawEquals(cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")})))
+	})
+
+	t.Run("infer collections option", func(t *testing.T) {
+		identity, err := New("identity", ".", nil, WithInferCollections(true))
+		require.NoError(t, err, "New should succeed")
+
+		input := cty.TupleVal([]cty.Value{cty.StringVal("a"), cty.NullVal(cty.DynamicPseudoType)})
+		result, err := identity.Call([]cty.Value{input})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, cty.List(cty.String), result.Type())
+	})
+
+	t.Run("compile returns metadata", func(t *testing.T) {
+		jqFunc, err := Compile("pick", ".[$key]", []string{"key"})
+		require.NoError(t, err, "Compile should succeed")
+		assert.Equal(t, "pick", jqFunc.Name)
+		assert.Equal(t, []string{"key"}, jqFunc.Params)
+		assert.Equal(t, ".[$key]", jqFunc.Query)
+		assert.NotNil(t, jqFunc.CompiledQuery)
+
+		result, err := jqFunc.Function().Call([]cty.Value{cty.StringVal(`{"a": "b"}`), cty.StringVal("a")})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, "b", result.AsString())
+	})
+
+	t.Run("execution errors without a range", func(t *testing.T) {
+		fn, err := New("fail", `error("boom")`, nil)
+		require.NoError(t, err, "New should succeed")
+
+		_, err = fn.Call([]cty.Value{cty.StringVal(`null`)})
+		require.Error(t, err)
+		var jqErr *JqExecutionError
+		require.ErrorAs(t, err, &jqErr)
+		assert.Equal(t, "fail", jqErr.FunctionName)
+		assert.Contains(t, err.Error(), "jq function fail: jq execution error")
+	})
+
+	t.Run("execution errors with a range", func(t *testing.T) {
+		rng := hcl.Range{Filename: "transforms.go", Start: hcl.Pos{Line: 12, Column: 1}, End: hcl.Pos{Line: 12, Column: 20}}
+		fn, err := New("fail", `error("boom")`, nil, WithRange(rng))
+		require.NoError(t, err, "New should succeed")
+
+		_, err = fn.Call([]cty.Value{cty.StringVal(`null`)})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "transforms.go:12")
+	})
+
+	t.Run("invalid definitions", func(t *testing.T) {
+		_, err := New("bad_query", ".[", nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "jq function bad_query: Failed to parse jq query")
+
+		_, err = New("undefined_var", "$missing", nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "jq function undefined_var")
+
+		_, err = New("bad_param", ".", []string{"not valid"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `invalid parameter name "not valid"`)
+
+		_, err = New("empty", "", nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "query must not be empty")
+	})
+}
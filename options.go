@@ -0,0 +1,290 @@
+package jqfunc
+
+import (
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/itchyny/gojq"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Option configures how jq functions are built. Options that correspond to
+// block attributes set the default for every block that does not set the
+// attribute itself.
+type Option func(*config)
+
+// config holds the settings collected from options
+type config struct {
+	// Function settings
+	sensitive        bool
+	inferCollections bool
+	returnType       cty.Type
+	results          ResultMode
+	timeout          time.Duration
+	converters       *ConverterRegistry
+	compilerOptions  []gojq.CompilerOption
+	inputFormat      Format
+	outputFormat     Format
+	nullInput        bool
+	csv              CSVOptions
+	json             JSONOptions
+	strictJSON       *StrictJSONOptions
+	defRange         hcl.Range
+
+	// Compilation settings
+	compilerOptionsKey string
+	compileCache       *CompileCache
+
+	// Decoding settings
+	blockType         string
+	userFuncBlockType string
+	evalContext       *hcl.EvalContext
+	duplicates        DuplicatePolicy
+	partialResults    bool
+}
+
+// newConfig applies options to the default settings
+func newConfig(opts []Option) *config {
+	cfg := &config{
+		returnType:        cty.NilType,
+		results:           ResultsAuto,
+		blockType:         "jq",
+		userFuncBlockType: "function",
+		duplicates:        DuplicatesReplace,
+		partialResults:    true,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// functionDef returns a definition of a function with the settings from cfg
+func (cfg *config) functionDef(name, query string, params []string, rng hcl.Range) *jqFunctionDef {
+	return &jqFunctionDef{
+		Name:               name,
+		Params:             params,
+		Query:              query,
+		Sensitive:          cfg.sensitive,
+		InferCollections:   cfg.inferCollections,
+		ReturnType:         cfg.returnType,
+		Results:            cfg.results,
+		Timeout:            cfg.timeout,
+		Converters:         cfg.converters,
+		InputFormat:        cfg.inputFormat,
+		OutputFormat:       cfg.outputFormat,
+		NullInput:          cfg.nullInput,
+		CSV:                cfg.csv,
+		JSON:               cfg.json,
+		StrictJSON:         cfg.strictJSON,
+		CompilerOptions:    cfg.compilerOptions,
+		CompilerOptionsKey: cfg.compilerOptionsKey,
+		CompileCache:       cfg.compileCache,
+		Range:              rng,
+	}
+}
+
+// registry returns the converter registry to use
+func (cfg *config) registry() *ConverterRegistry {
+	if cfg.converters != nil {
+		return cfg.converters
+	}
+	return DefaultConverters
+}
+
+// ResultMode selects how the values a query emits become the function result
+type ResultMode string
+
+const (
+	// ResultsAuto returns a single result as is, several results as an
+	// array, and null when there are none
+	ResultsAuto ResultMode = "auto"
+
+	// ResultsArray always returns an array of all results
+	ResultsArray ResultMode = "array"
+
+	// ResultsFirst returns the first result, or null when there are none,
+	// and stops the query after it
+	ResultsFirst ResultMode = "first"
+)
+
+func (m ResultMode) valid() bool {
+	return m == ResultsAuto || m == ResultsArray || m == ResultsFirst
+}
+
+// DuplicatePolicy selects what happens when two blocks define functions with
+// the same name
+type DuplicatePolicy int
+
+const (
+	// DuplicatesReplace silently replaces the earlier function
+	DuplicatesReplace DuplicatePolicy = iota
+
+	// DuplicatesWarn replaces the earlier function with a warning
+	DuplicatesWarn
+
+	// DuplicatesError keeps the earlier function and reports an error
+	DuplicatesError
+)
+
+// WithSensitive marks every result with SensitiveMark, like sensitive = true
+func WithSensitive(sensitive bool) Option {
+	return func(cfg *config) {
+		cfg.sensitive = sensitive
+	}
+}
+
+// WithInferCollections makes results whose element types unify become lists
+// and maps, like infer_collections = true
+func WithInferCollections(infer bool) Option {
+	return func(cfg *config) {
+		cfg.inferCollections = infer
+	}
+}
+
+// WithReturnType declares the result type, like return_type
+func WithReturnType(ty cty.Type) Option {
+	return func(cfg *config) {
+		cfg.returnType = ty
+	}
+}
+
+// WithConverters sets the registry used for capsule-typed values instead of
+// DefaultConverters
+func WithConverters(converters *ConverterRegistry) Option {
+	return func(cfg *config) {
+		cfg.converters = converters
+	}
+}
+
+// WithRange sets the source location reported in errors
+func WithRange(rng hcl.Range) Option {
+	return func(cfg *config) {
+		cfg.defRange = rng
+	}
+}
+
+// WithResults sets how the values a query emits become the function result,
+// like results = "array"
+func WithResults(mode ResultMode) Option {
+	return func(cfg *config) {
+		cfg.results = mode
+	}
+}
+
+// WithTimeout limits how long a single call may run, like timeout = "2s".
+// Zero means no limit.
+func WithTimeout(timeout time.Duration) Option {
+	return func(cfg *config) {
+		cfg.timeout = timeout
+	}
+}
+
+// WithInputFormat sets the format of string input, like input_format = "yaml"
+func WithInputFormat(format Format) Option {
+	return func(cfg *config) {
+		cfg.inputFormat = format
+	}
+}
+
+// WithOutputFormat makes every result a string in the given format, like
+// output_format = "yaml"
+func WithOutputFormat(format Format) Option {
+	return func(cfg *config) {
+		cfg.outputFormat = format
+	}
+}
+
+// WithNullInput runs queries once with null as their input, leaving every
+// document to input and inputs, like null_input = true
+func WithNullInput(nullInput bool) Option {
+	return func(cfg *config) {
+		cfg.nullInput = nullInput
+	}
+}
+
+// WithCSVOptions configures the csv and tsv input formats, like a csv block
+func WithCSVOptions(opts CSVOptions) Option {
+	return func(cfg *config) {
+		cfg.csv = opts
+	}
+}
+
+// WithJSONOptions configures the JSON text of results, like a json block
+func WithJSONOptions(opts JSONOptions) Option {
+	return func(cfg *config) {
+		cfg.json = opts
+	}
+}
+
+// WithStrictJSON parses JSON string input strictly, like a strict_json block
+func WithStrictJSON(opts StrictJSONOptions) Option {
+	return func(cfg *config) {
+		cfg.strictJSON = &opts
+	}
+}
+
+// WithCompilerOptions adds gojq compiler options, such as
+// gojq.WithModuleLoader, gojq.WithEnvironLoader or gojq.WithFunction, used
+// when compiling every query
+func WithCompilerOptions(opts ...gojq.CompilerOption) Option {
+	return func(cfg *config) {
+		cfg.compilerOptions = append(cfg.compilerOptions, opts...)
+	}
+}
+
+// WithCompilerOptionsKey names the compiler options for the compile cache.
+// Queries compiled with compiler options are only cached when the options
+// have a key, and the key must change whenever the options do.
+func WithCompilerOptionsKey(key string) Option {
+	return func(cfg *config) {
+		cfg.compilerOptionsKey = key
+	}
+}
+
+// WithCompileCache reuses compiled queries from cache and adds new ones to it
+func WithCompileCache(cache *CompileCache) Option {
+	return func(cfg *config) {
+		cfg.compileCache = cache
+	}
+}
+
+// WithBlockType sets the type of the blocks that define functions
+func WithBlockType(blockType string) Option {
+	return func(cfg *config) {
+		cfg.blockType = blockType
+	}
+}
+
+// WithUserFunctionBlockType sets the type of the blocks that define HCL user
+// functions for DecodeFunctions
+func WithUserFunctionBlockType(blockType string) Option {
+	return func(cfg *config) {
+		cfg.userFuncBlockType = blockType
+	}
+}
+
+// WithEvalContext sets the context used to evaluate block attributes such as
+// query, so they may refer to variables and functions. Without it they must
+// be constant.
+func WithEvalContext(ctx *hcl.EvalContext) Option {
+	return func(cfg *config) {
+		cfg.evalContext = ctx
+	}
+}
+
+// WithDuplicates sets the policy for functions defined more than once
+func WithDuplicates(policy DuplicatePolicy) Option {
+	return func(cfg *config) {
+		cfg.duplicates = policy
+	}
+}
+
+// WithPartialResults controls whether the functions from valid blocks are
+// returned when other blocks have errors (the default). When false, any
+// error diagnostic makes decoding return no functions.
+func WithPartialResults(partial bool) Option {
+	return func(cfg *config) {
+		cfg.partialResults = partial
+	}
+}
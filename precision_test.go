@@ -0,0 +1,166 @@
+package jqfunc
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestNumericPrecision(t *testing.T) {
+	hclCode := `
+jqfunction "identity" {
+    params = []
+    query = "."
+}
+
+jqfunction "get_id" {
+    params = []
+    query = ".id"
+}
+
+jqfunction "add" {
+    params = [n]
+    query = ". + $n"
+}
+
+jqfunction "double" {
+    params = []
+    query = ". * 2"
+}
+
+jqfunction "identity_jq" {
+    params = []
+    query = "."
+
+    json {
+        jq_compatible = true
+    }
+}
+`
+	parser := hclparse.NewParser()
+	file, diags := parser.ParseHCL([]byte(hclCode), "precision.hcl")
+	require.False(t, diags.HasErrors(), "HCL parsing should succeed: %s", diags)
+
+	functions, _, diags := DecodeJqFunctions(file.Body, "jqfunction")
+	require.False(t, diags.HasErrors(), "Function decoding should succeed: %s", diags)
+
+	t.Run("big integers survive JSON round trip", func(t *testing.T) {
+		input := `{"id":123456789012345678901234567890,"ts":1700000000123456789}`
+
+		result, err := functions["identity"].Call([]cty.Value{cty.StringVal(input)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, input, result.AsString(), "Integers should not be rounded")
+	})
+
+	t.Run("big integer extracted from JSON", func(t *testing.T) {
+		input := `{"id": 9007199254740993}`
+
+		result, err := functions["get_id"].Call([]cty.Value{cty.StringVal(input)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, "9007199254740993", result.AsString(), "2^53+1 should not be rounded")
+	})
+
+	t.Run("big integer arithmetic with cty values", func(t *testing.T) {
+		big1, _ := new(big.Float).SetPrec(512).SetString("123456789012345678901234567890")
+		expected, _ := new(big.Float).SetPrec(512).SetString("123456789012345678901234567891")
+
+		result, err := functions["add"].Call([]cty.Value{cty.NumberVal(big1), cty.NumberIntVal(1)})
+		require.NoError(t, err, "Function call should succeed")
+		require.Equal(t, cty.Number, result.Type())
+		assert.Equal(t, 0, result.AsBigFloat().Cmp(expected), "Expected %s, got %s", expected.Text('f', 0), result.AsBigFloat().Text('f', 0))
+	})
+
+	t.Run("nanosecond timestamp from cty", func(t *testing.T) {
+		input := cty.ObjectVal(map[string]cty.Value{
+			"id": cty.NumberIntVal(1700000000123456789),
+		})
+
+		result, err := functions["get_id"].Call([]cty.Value{input})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.RawEquals(cty.NumberIntVal(1700000000123456789)), "Got %#v", result)
+	})
+
+	t.Run("no exponent notation in output", func(t *testing.T) {
+		result, err := functions["identity"].Call([]cty.Value{cty.StringVal(`[1e21, 0.0000001, 12.34]`)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, "[1000000000000000000000,0.0000001,12.34]", result.AsString())
+	})
+
+	t.Run("decimals survive JSON round trip", func(t *testing.T) {
+		input := `{"amount":12345678901234567.89,"rate":-0.1000000000000000000001,"small":1.5e-7}`
+
+		result, err := functions["identity"].Call([]cty.Value{cty.StringVal(input)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, `{"amount":12345678901234567.89,"rate":-0.1000000000000000000001,"small":0.00000015}`, result.AsString())
+
+		result, err = functions["get_id"].Call([]cty.Value{cty.StringVal(`{"id": 0.12345678901234567890}`)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, "0.1234567890123456789", result.AsString())
+	})
+
+	t.Run("decimals in jq format", func(t *testing.T) {
+		result, err := functions["identity_jq"].Call([]cty.Value{cty.StringVal(`[0.000012345678901234567890, 1.5e-7, 12345678901234567.89]`)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, "[1.234567890123456789e-05,1.5e-07,12345678901234567.89]", result.AsString())
+	})
+
+	t.Run("computed decimals are float64", func(t *testing.T) {
+		result, err := functions["double"].Call([]cty.Value{cty.StringVal(`12345678901234567.89`)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, "24691357802469136", result.AsString())
+	})
+
+	t.Run("decimals that round alike are not restored", func(t *testing.T) {
+		result, err := functions["identity"].Call([]cty.Value{cty.StringVal(`[0.10000000000000000001, 0.10000000000000000002]`)})
+		require.NoError(t, err, "Function call should succeed")
+		assert.Equal(t, "[0.1,0.1]", result.AsString())
+	})
+
+	t.Run("decimals from cty", func(t *testing.T) {
+		amount := cty.MustParseNumberVal("12345678901234567.89")
+		input := cty.ObjectVal(map[string]cty.Value{"id": amount})
+
+		result, err := functions["get_id"].Call([]cty.Value{input})
+		require.NoError(t, err, "Function call should succeed")
+		assert.True(t, result.Equals(amount).True(), "Got %s", result.AsBigFloat().Text('f', -1))
+	})
+
+	t.Run("trailing data is rejected", func(t *testing.T) {
+		_, err := functions["identity"].Call([]cty.Value{cty.StringVal(`{} {}`)})
+		require.Error(t, err, "Multiple documents should be rejected")
+		assert.Contains(t, err.Error(), "invalid JSON input")
+	})
+}
+
+func TestJSONNumberToJq(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"42", 42},
+		{"-7", -7},
+		{"1.5", 1.5},
+		{"1e3", 1000.0},
+		{"123456789012345678901234567890", mustBigInt("123456789012345678901234567890")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := decodeJSON([]byte(tt.input))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func mustBigInt(s string) *big.Int {
+	i, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("invalid big integer " + s)
+	}
+	return i
+}
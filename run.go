@@ -0,0 +1,244 @@
+package jqfunc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/itchyny/gojq"
+)
+
+// Run executes the query on a Go value without converting to or from cty.
+// The input and args (one per parameter) may be nil, bool, any Go integer or
+// floating-point type, json.Number, *big.Int, string, []interface{} or
+// map[string]interface{}; they are copied, never modified. Run returns every
+// value the query emits, or only the first one when Results is ResultsFirst.
+// Integers in the results are int or *big.Int, other numbers float64.
+//
+// Errors, including cancellation of ctx and the function's Timeout, are
+// returned as *JqExecutionError.
+func (jqFunc *JqFunction) Run(ctx context.Context, input interface{}, args ...interface{}) ([]interface{}, error) {
+	jqInput, err := normalizeGoValue(input)
+	if err != nil {
+		return nil, jqFunc.executionError(fmt.Errorf("failed to convert input: %w", err))
+	}
+	variableValues, err := jqFunc.normalizeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return jqFunc.execute(ctx, []interface{}{jqInput}, variableValues)
+}
+
+// RunJSON executes the query on a JSON document and returns the result as
+// JSON. The results are combined as for JSON string input to the HCL
+// function, following the Results mode: one result is returned as is, several
+// as an array and none as null. Unlike the HCL function, a string result is
+// returned JSON-encoded. The args are Go values, as for Run.
+func (jqFunc *JqFunction) RunJSON(ctx context.Context, input []byte, args ...interface{}) ([]byte, error) {
+	converter := jqFunc.converter()
+	d, err := jqFunc.newJSONDecoder(input, dialectJSON, converter)
+	if err != nil {
+		return nil, jqFunc.executionError(fmt.Errorf("invalid JSON input: %w", err))
+	}
+	jqInput, err := d.document()
+	if err != nil {
+		return nil, jqFunc.executionError(fmt.Errorf("invalid JSON input: %w", err))
+	}
+	variableValues, err := jqFunc.normalizeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := jqFunc.execute(ctx, []interface{}{jqInput}, variableValues)
+	if err != nil {
+		return nil, err
+	}
+	output, err := jqFunc.JSON.forCall(converter).encode(jqFunc.combineResults(results))
+	if err != nil {
+		return nil, jqFunc.executionError(fmt.Errorf("failed to marshal result: %w", err))
+	}
+	return output, nil
+}
+
+// normalizeArgs checks the number of arguments and converts them for gojq
+func (jqFunc *JqFunction) normalizeArgs(args []interface{}) ([]interface{}, error) {
+	if len(args) != len(jqFunc.Params) {
+		return nil, jqFunc.executionError(fmt.Errorf("expected %d arguments after the input, got %d", len(jqFunc.Params), len(args)))
+	}
+
+	variableValues := make([]interface{}, len(args))
+	for i, arg := range args {
+		value, err := normalizeGoValue(arg)
+		if err != nil {
+			return nil, jqFunc.executionError(fmt.Errorf("failed to convert parameter %s: %w", jqFunc.Params[i], err))
+		}
+		variableValues[i] = value
+	}
+	return variableValues, nil
+}
+
+// execute runs the compiled query on converted values and collects the
+// results
+func (jqFunc *JqFunction) execute(ctx context.Context, inputs []interface{}, variableValues []interface{}) ([]interface{}, error) {
+	var results []interface{}
+	err := jqFunc.stream(ctx, inputs, variableValues, func(result interface{}) bool {
+		results = append(results, result)
+		return true
+	})
+	return results, err
+}
+
+// stream runs the compiled query on the converted inputs, passing each
+// result to yield until it returns false. Like jq, the query runs on each
+// input in turn, except that with NullInput it runs once on null; for input
+// formats that feed inputs, input and inputs read the inputs it has not run
+// on. The timeout covers all runs together. Values are handed to gojq as is,
+// so they must not be shared with the caller: gojq normalizes them in place.
+func (jqFunc *JqFunction) stream(ctx context.Context, inputs []interface{}, variableValues []interface{}, yield func(interface{}) bool) error {
+	runCtx := ctx
+	if jqFunc.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, jqFunc.Timeout)
+		defer cancel()
+	}
+
+	source := &inputSource{values: inputs}
+	code := jqFunc.CompiledQuery
+	if jqFunc.InputFormat.feedsInputs() {
+		fed, err := jqFunc.getFedQuery()
+		if err != nil {
+			return jqFunc.executionError(err)
+		}
+		defer jqFunc.putFedQuery(fed)
+		fed.source.values = inputs
+		code, source = fed.code, fed.source
+	}
+
+	run := func(input interface{}) (bool, error) {
+		iter := code.RunWithContext(runCtx, input, variableValues...)
+		for {
+			result, hasResult := iter.Next()
+			if !hasResult {
+				return true, nil
+			}
+
+			// Check for execution error
+			if err, ok := result.(error); ok {
+				if runCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+					// The function's own timeout rather than the caller's context
+					err = fmt.Errorf("timed out after %s: %w", jqFunc.Timeout, err)
+				}
+				return false, jqFunc.executionError(fmt.Errorf("jq execution error: %w", err))
+			}
+
+			if !yield(result) || jqFunc.Results == ResultsFirst {
+				return false, nil
+			}
+		}
+	}
+
+	if jqFunc.NullInput {
+		_, err := run(nil)
+		return err
+	}
+	for {
+		input, ok := source.Next()
+		if !ok {
+			return nil
+		}
+		if more, err := run(input); !more {
+			return err
+		}
+	}
+}
+
+// fedQuery is the query compiled so that input and inputs read from source,
+// for input formats that feed inputs. It serves one call at a time.
+type fedQuery struct {
+	code   *gojq.Code
+	source *inputSource
+}
+
+// getFedQuery takes a fedQuery from the function's pool, compiling the query
+// again only when every one is in use
+func (jqFunc *JqFunction) getFedQuery() (*fedQuery, error) {
+	if jqFunc.fedQueries != nil {
+		if fed, ok := jqFunc.fedQueries.Get().(*fedQuery); ok {
+			return fed, nil
+		}
+	}
+	source := &inputSource{}
+	code, err := jqFunc.compileWithInputs(source)
+	if err != nil {
+		return nil, err
+	}
+	return &fedQuery{code: code, source: source}, nil
+}
+
+// putFedQuery returns a fedQuery to the pool once its call is done, dropping
+// any inputs the call left unread
+func (jqFunc *JqFunction) putFedQuery(fed *fedQuery) {
+	fed.source.values = nil
+	if jqFunc.fedQueries != nil {
+		jqFunc.fedQueries.Put(fed)
+	}
+}
+
+// compileWithInputs compiles the query again so that input and inputs read
+// from source
+func (jqFunc *JqFunction) compileWithInputs(source *inputSource) (*gojq.Code, error) {
+	query, err := gojq.Parse(jqFunc.Query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse jq query: %w", err)
+	}
+	opts := append([]gojq.CompilerOption{gojq.WithInputIter(source)}, jqFunc.compilerOptions...)
+	code, err := compileParsed(query, jqFunc.Params, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile jq query: %w", err)
+	}
+	return code, nil
+}
+
+// inputSource hands out the inputs of a call, to the query runs and to
+// input and inputs alike
+type inputSource struct {
+	values []interface{}
+}
+
+// Next implements gojq.Iter
+func (s *inputSource) Next() (interface{}, bool) {
+	if len(s.values) == 0 {
+		return nil, false
+	}
+	v := s.values[0]
+	s.values = s.values[1:]
+	return v, true
+}
+
+// combineResults turns the values a query emitted into a single value
+// according to the result mode
+func (jqFunc *JqFunction) combineResults(results []interface{}) interface{} {
+	switch {
+	case jqFunc.Results == ResultsArray:
+		// Always an array, even for zero or one result
+		return append([]interface{}{}, results...)
+	case len(results) == 0:
+		// No results: return null
+		return nil
+	case len(results) == 1:
+		// Single result: return the element directly
+		return results[0]
+	default:
+		// Multiple results: return as array
+		return results
+	}
+}
+
+// executionError wraps an error with the function's details
+func (jqFunc *JqFunction) executionError(cause error) *JqExecutionError {
+	return &JqExecutionError{
+		FunctionName: jqFunc.Name,
+		Query:        jqFunc.Query,
+		Range:        jqFunc.Range,
+		Cause:        cause,
+	}
+}
@@ -0,0 +1,155 @@
+package jqfunc
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("go values", func(t *testing.T) {
+		fn, err := Compile("total", "[.items[] | .price * .qty] | add + $shipping", []string{"shipping"})
+		require.NoError(t, err)
+
+		input := map[string]interface{}{
+			"items": []interface{}{
+				map[string]interface{}{"price": 2.5, "qty": int64(2)},
+				map[string]interface{}{"price": uint8(10), "qty": 1},
+			},
+		}
+		results, err := fn.Run(ctx, input, 5)
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{20.0}, results)
+	})
+
+	t.Run("all results", func(t *testing.T) {
+		fn, err := Compile("each", ".[]", nil)
+		require.NoError(t, err)
+
+		results, err := fn.Run(ctx, []interface{}{1, "a", nil})
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{1, "a", nil}, results)
+
+		results, err = fn.Run(ctx, []interface{}{})
+		require.NoError(t, err)
+		assert.Empty(t, results)
+
+		first, err := Compile("first", ".[]", nil, WithResults(ResultsFirst))
+		require.NoError(t, err)
+		results, err = first.Run(ctx, []interface{}{1, 2})
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{1}, results)
+	})
+
+	t.Run("integers stay exact", func(t *testing.T) {
+		fn, err := Compile("identity", ".", nil)
+		require.NoError(t, err)
+
+		huge := mustBigInt("123456789012345678901234567890")
+		results, err := fn.Run(ctx, []interface{}{huge, uint64(1 << 63)})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, []interface{}{huge, new(big.Int).SetUint64(1 << 63)}, results[0])
+	})
+
+	t.Run("input is not modified", func(t *testing.T) {
+		fn, err := Compile("identity", ".", nil)
+		require.NoError(t, err)
+
+		input := map[string]interface{}{"n": int64(1)}
+		_, err = fn.Run(ctx, input)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), input["n"])
+	})
+
+	t.Run("argument count", func(t *testing.T) {
+		fn, err := Compile("pick", ".[$key]", []string{"key"})
+		require.NoError(t, err)
+
+		_, err = fn.Run(ctx, map[string]interface{}{})
+		var jqErr *JqExecutionError
+		require.ErrorAs(t, err, &jqErr)
+		assert.Equal(t, "pick", jqErr.FunctionName)
+		assert.Contains(t, err.Error(), "expected 1 arguments after the input, got 0")
+	})
+
+	t.Run("unsupported input", func(t *testing.T) {
+		fn, err := Compile("identity", ".", nil)
+		require.NoError(t, err)
+
+		_, err = fn.Run(ctx, struct{}{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to convert input: unsupported value of type struct {}")
+	})
+
+	t.Run("context cancellation", func(t *testing.T) {
+		fn, err := Compile("forever", "last(repeat(1))", nil)
+		require.NoError(t, err)
+
+		cancelCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		_, err = fn.Run(cancelCtx, nil)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), "Got %v", err)
+		assert.NotContains(t, err.Error(), "timed out after")
+
+		fn.Timeout = 20 * time.Millisecond
+		_, err = fn.Run(ctx, nil)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), "Got %v", err)
+		assert.Contains(t, err.Error(), "timed out after 20ms")
+	})
+}
+
+func TestRunJSON(t *testing.T) {
+	ctx := context.Background()
+
+	fn, err := Compile("names", `.[] | select(.age >= $min) | .name`, []string{"min"})
+	require.NoError(t, err)
+	input := []byte(`[{"name": "a", "age": 30}, {"name": "b", "age": 20}, {"name": "c", "age": 40}]`)
+
+	t.Run("several results", func(t *testing.T) {
+		output, err := fn.RunJSON(ctx, input, 25)
+		require.NoError(t, err)
+		assert.Equal(t, `["a","c"]`, string(output))
+	})
+
+	t.Run("string result is encoded", func(t *testing.T) {
+		output, err := fn.RunJSON(ctx, input, 35)
+		require.NoError(t, err)
+		assert.Equal(t, `"c"`, string(output))
+	})
+
+	t.Run("no results", func(t *testing.T) {
+		output, err := fn.RunJSON(ctx, input, 50)
+		require.NoError(t, err)
+		assert.Equal(t, `null`, string(output))
+	})
+
+	t.Run("matches the HCL function", func(t *testing.T) {
+		doc := `{"a": [1, 2.5, 123456789012345678901234567890], "b": {"c": null}}`
+		identity, err := Compile("identity", ".", nil)
+		require.NoError(t, err)
+
+		output, err := identity.RunJSON(ctx, []byte(doc))
+		require.NoError(t, err)
+		result, err := identity.Function().Call([]cty.Value{cty.StringVal(doc)})
+		require.NoError(t, err)
+		assert.Equal(t, result.AsString(), string(output))
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		_, err := fn.RunJSON(ctx, []byte(`[`), 1)
+		var jqErr *JqExecutionError
+		require.ErrorAs(t, err, &jqErr)
+		assert.Contains(t, err.Error(), "invalid JSON input")
+	})
+}
@@ -0,0 +1,62 @@
+package jqfunc
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// StrictJSONOptions turns on strict parsing of JSON string input, for the
+// json, jsonc, json5 and ndjson input formats. Strict parsing rejects
+// duplicate object keys, invalid UTF-8 anywhere in the input and \u escapes
+// of unpaired surrogates, all of which are otherwise accepted as
+// encoding/json accepts them, and errors give the byte offset of the
+// problem.
+type StrictJSONOptions struct {
+	// MaxSize limits the size of the input in bytes; zero means no limit
+	MaxSize int
+
+	// MaxDepth limits the nesting of arrays and objects; zero means the
+	// usual limit of 10000
+	MaxDepth int
+}
+
+// newJSONDecoder returns a decoder for JSON string input in the given
+// dialect that records key order and decimals in the converter of the call,
+// checking the input first if the function parses strictly
+func (jqFunc *JqFunction) newJSONDecoder(data []byte, dialect jsonDialect, c *converter) (*jsonDecoder, error) {
+	d := newJSONDecoder(data, dialect, c.keyOrder)
+	d.decimals = &c.decimals
+	strict := jqFunc.StrictJSON
+	if strict == nil {
+		return d, nil
+	}
+
+	if strict.MaxSize > 0 && len(data) > strict.MaxSize {
+		return nil, fmt.Errorf("input of %d bytes exceeds the limit of %d bytes", len(data), strict.MaxSize)
+	}
+	if offset := invalidUTF8Offset(data); offset >= 0 {
+		return nil, fmt.Errorf("invalid UTF-8 at offset %d", offset)
+	}
+	d.strict = true
+	if strict.MaxDepth > 0 {
+		d.maxDepth = strict.MaxDepth
+	}
+	return d, nil
+}
+
+// invalidUTF8Offset returns the offset of the first byte of data that is not
+// valid UTF-8, or -1 if it is all valid
+func invalidUTF8Offset(data []byte) int {
+	for i := 0; i < len(data); {
+		if data[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size == 1 {
+			return i
+		}
+		i += size
+	}
+	return -1
+}
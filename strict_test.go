@@ -0,0 +1,128 @@
+package jqfunc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestStrictJSON(t *testing.T) {
+	body := parseTestBody(t, `
+jq "strict" {
+    query = "."
+
+    strict_json {
+        max_size  = 64
+        max_depth = 3
+    }
+}
+
+jq "lenient" {
+    query = "."
+}
+
+jq "strict_lines" {
+    input_format = "ndjson"
+    query        = ".id"
+
+    strict_json {}
+}
+
+jq "strict_jsonc" {
+    input_format = "jsonc"
+    query        = "."
+
+    strict_json {}
+}
+`)
+	functions, _, diags := DecodeJqFunctions(body, "jq")
+	require.False(t, diags.HasErrors(), "Decoding should succeed: %s", diags)
+
+	t.Run("valid input", func(t *testing.T) {
+		result, err := functions["strict"].Call([]cty.Value{cty.StringVal(`{"a": [[1]], "b": "café 😀"}`)})
+		require.NoError(t, err)
+		assert.Equal(t, cty.StringVal(`{"a":[[1]],"b":"café 😀"}`), result)
+	})
+
+	errorTests := []struct {
+		name     string
+		function string
+		input    string
+		expected string
+	}{
+		{"duplicate key", "strict", `{"a": 1, "b": {"c": 2, "c": 3}}`, `duplicate key "c" at offset 23`},
+		{"top-level duplicate key", "strict", `{"a": 1, "a": 2}`, `duplicate key "a" at offset 9`},
+		{"invalid UTF-8", "strict", "{\"a\": \"ok\", \"b\": \"\xff\"}", "invalid UTF-8 at offset 18"},
+		{"truncated UTF-8", "strict", "[\"\xe2\x82\"]", "invalid UTF-8 at offset 2"},
+		{"unpaired surrogate", "strict", `["\ud83d", 1]`, "unpaired surrogate in string escape at offset 2"},
+		{"lone low surrogate", "strict", `["a\ude00"]`, "unpaired surrogate in string escape at offset 3"},
+		{"too deep", "strict", `{"a": [[[1]]]}`, "exceeded max depth at offset 8"},
+		{"too large", "strict", `"` + strings.Repeat("x", 63) + `"`, "input of 65 bytes exceeds the limit of 64 bytes"},
+		{"duplicate key on a later line", "strict_lines", "{\"id\": 1}\n{\"id\": 2, \"id\": 3}\n", `line 2: duplicate key "id" at offset 20`},
+		{"invalid UTF-8 in a comment", "strict_jsonc", "// \xc3\n{}", "invalid UTF-8 at offset 3"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := functions[tt.function].Call([]cty.Value{cty.StringVal(tt.input)})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+
+	t.Run("lenient by default", func(t *testing.T) {
+		result, err := functions["lenient"].Call([]cty.Value{cty.StringVal("{\"a\": 1, \"a\": \"\xff\\ud83d\"}")})
+		require.NoError(t, err)
+		assert.Equal(t, cty.StringVal(`{"a":"��"}`), result)
+	})
+
+	t.Run("the default depth limit applies", func(t *testing.T) {
+		deep := strings.Repeat("[", maxJSONDepth+1) + strings.Repeat("]", maxJSONDepth+1)
+		_, err := functions["strict_lines"].Call([]cty.Value{cty.StringVal(deep)})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "exceeded max depth at offset 10000")
+	})
+
+	t.Run("run json", func(t *testing.T) {
+		fn, err := Compile("strict", ".", nil, WithStrictJSON(StrictJSONOptions{MaxDepth: 1}))
+		require.NoError(t, err)
+		_, err = fn.RunJSON(context.Background(), []byte(`{"a": 1, "a": 2}`))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `invalid JSON input: duplicate key "a" at offset 9`)
+		_, err = fn.RunJSON(context.Background(), []byte(`[[]]`))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `invalid JSON input: exceeded max depth at offset 1`)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := New("bad", ".", nil, WithInputFormat(FormatYAML), WithStrictJSON(StrictJSONOptions{}))
+		assert.EqualError(t, err, `jq function bad: strict_json requires JSON input, not input_format = "yaml"`)
+		_, err = New("bad", ".", nil, WithStrictJSON(StrictJSONOptions{MaxDepth: maxJSONDepth + 1}))
+		assert.EqualError(t, err, `jq function bad: strict_json max_depth must be between 0 and 10000, got 10001`)
+
+		body := parseTestBody(t, `
+jq "negative" {
+    query = "."
+
+    strict_json {
+        max_size = -1
+    }
+}
+
+jq "too_deep" {
+    query = "."
+
+    strict_json {
+        max_depth = 20000
+    }
+}
+`)
+		_, _, diags := DecodeJqFunctions(body, "jq")
+		require.Len(t, diags, 2)
+		assert.Equal(t, "max_size must be a whole number of at least 0", diags[0].Detail)
+		assert.Equal(t, "max_depth must be a whole number from 0 to 10000", diags[1].Detail)
+	})
+}
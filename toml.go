@@ -0,0 +1,63 @@
+package jqfunc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// decodeTOML parses a TOML document into a jq object. Offset date-times
+// become RFC 3339 strings, and local dates, times and date-times their TOML
+// text. Errors give the line and column.
+func decodeTOML(data []byte) (interface{}, error) {
+	var v map[string]interface{}
+	if err := toml.Unmarshal(data, &v); err != nil {
+		var decodeErr *toml.DecodeError
+		if errors.As(err, &decodeErr) {
+			line, column := decodeErr.Position()
+			return nil, fmt.Errorf("line %d, column %d: %s", line, column, strings.TrimPrefix(decodeErr.Error(), "toml: "))
+		}
+		return nil, err
+	}
+	if v == nil {
+		v = map[string]interface{}{}
+	}
+	return tomlToJq(v)
+}
+
+// tomlToJq converts the values go-toml decodes to gojq values
+func tomlToJq(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, elem := range v {
+			converted, err := tomlToJq(elem)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = converted
+		}
+		return v, nil
+	case []interface{}:
+		for i, elem := range v {
+			converted, err := tomlToJq(elem)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+		return v, nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case toml.LocalDate:
+		return v.String(), nil
+	case toml.LocalTime:
+		return v.String(), nil
+	case toml.LocalDateTime:
+		return v.String(), nil
+	default:
+		return normalizeGoValue(v)
+	}
+}
@@ -0,0 +1,89 @@
+package jqfunc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestDecodeTOML(t *testing.T) {
+	tests := []struct {
+		name     string
+		toml     string
+		expected interface{}
+	}{
+		{"scalars", "s = \"x\"\nn = -7\nbig = 9223372036854775807\nf = 1.5\nb = true\nhex = 0x1F\n",
+			map[string]interface{}{"s": "x", "n": -7, "big": 9223372036854775807, "f": 1.5, "b": true, "hex": 31}},
+		{"date-times", "odt = 1979-05-27T07:32:00.5-08:00\nldt = 1979-05-27T07:32:00\nld = 1979-05-27\nlt = 07:32:00\n",
+			map[string]interface{}{"odt": "1979-05-27T07:32:00.5-08:00", "ldt": "1979-05-27T07:32:00", "ld": "1979-05-27", "lt": "07:32:00"}},
+		{"tables", "[server]\nports = [80, 443]\n\n[server.tls]\nenabled = true\n",
+			map[string]interface{}{"server": map[string]interface{}{
+				"ports": []interface{}{80, 443},
+				"tls":   map[string]interface{}{"enabled": true},
+			}}},
+		{"arrays of tables", "[[hosts]]\nname = \"a\"\n\n[[hosts]]\nname = \"b\"\n",
+			map[string]interface{}{"hosts": []interface{}{
+				map[string]interface{}{"name": "a"},
+				map[string]interface{}{"name": "b"},
+			}}},
+		{"empty", "", map[string]interface{}{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := decodeTOML([]byte(tt.toml))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, v)
+		})
+	}
+
+	t.Run("errors give the line and column", func(t *testing.T) {
+		_, err := decodeTOML([]byte("a = 1\nb = \n"))
+		require.Error(t, err)
+		assert.Equal(t, "line 2, column 5: unexpected character U+000A at start of value", err.Error())
+
+		_, err = decodeTOML([]byte("a = 1\na = 2\n"))
+		require.Error(t, err)
+		assert.Equal(t, "line 2, column 1: key a is already defined", err.Error())
+	})
+}
+
+func TestTOMLFormat(t *testing.T) {
+	body := parseTestBody(t, `
+jq "ports" {
+    input_format = "toml"
+    query        = "[.servers[].port]"
+}
+
+jq "owner" {
+    input_format = "toml"
+    return_type  = object({name = string, since = string})
+    query        = ".owner"
+}
+`)
+	functions, _, diags := DecodeJqFunctions(body, "jq")
+	require.False(t, diags.HasErrors(), "Decoding should succeed: %s", diags)
+
+	t.Run("toml input", func(t *testing.T) {
+		result, err := functions["ports"].Call([]cty.Value{cty.StringVal("[[servers]]\nport = 80\n\n[[servers]]\nport = 8080\n")})
+		require.NoError(t, err)
+		assert.Equal(t, cty.StringVal(`[80,8080]`), result)
+	})
+
+	t.Run("return type", func(t *testing.T) {
+		result, err := functions["owner"].Call([]cty.Value{cty.StringVal("[owner]\nname = \"Tom\"\nsince = 2010-04-01\n")})
+		require.NoError(t, err)
+		assert.True(t, result.RawEquals(cty.ObjectVal(map[string]cty.Value{
+			"name":  cty.StringVal("Tom"),
+			"since": cty.StringVal("2010-04-01"),
+		})), "Got %#v", result)
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		_, err := functions["ports"].Call([]cty.Value{cty.StringVal("[servers\n")})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid TOML input: line 1, column")
+	})
+}
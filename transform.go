@@ -0,0 +1,270 @@
+package jqfunc
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/customdecode"
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/itchyny/gojq"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// hcldec.Spec cannot be implemented outside hcldec, so transforms are built
+// from its public parts: an attribute is an AttrSpec whose type is a capsule
+// type with a custom expression decoder, and a block is a BlockSpec whose
+// attributes are compiled by a ValidateSpec, so that compile errors are
+// diagnostics on the query, and combined by a TransformFuncSpec.
+
+var jqFunctionType = reflect.TypeOf(JqFunction{})
+
+// TransformSpec returns an hcldec.Spec for an attribute holding a jq
+// transform. The attribute is either a query string or an object with the
+// query and bare parameter names, like a jq block:
+//
+//	transform = ".items[].name"
+//	transform = { query = ".items[] | select(.kind == $kind)", params = [kind] }
+//
+// The spec decodes to a capsule value holding the compiled *JqFunction, which
+// TransformFunction extracts; it is null when the attribute is absent. The
+// options apply as they do to New, and the function is named after the
+// attribute.
+func TransformSpec(name string, required bool, opts ...Option) hcldec.Spec {
+	cfg := newConfig(opts)
+	var ty cty.Type
+	ty = transformType(func(expr hcl.Expression, ctx *hcl.EvalContext) (cty.Value, hcl.Diagnostics) {
+		query, params, diags := decodeTransformExpr(expr, ctx)
+		if diags.HasErrors() {
+			return cty.NilVal, diags
+		}
+		jqFunc, compileDiags := compileTransform(name, query, params, expr.Range(), cfg)
+		diags = diags.Extend(compileDiags)
+		if compileDiags.HasErrors() {
+			return cty.NilVal, diags
+		}
+		return cty.CapsuleVal(ty, jqFunc), diags
+	})
+
+	return &hcldec.AttrSpec{Name: name, Type: ty, Required: required}
+}
+
+// TransformBlockSpec returns an hcldec.Spec for a block holding a jq
+// transform, with the same query and params attributes as a jq block:
+//
+//	transform {
+//	    params = [kind]
+//	    query  = ".items[] | select(.kind == $kind)"
+//	}
+//
+// It decodes like TransformSpec, naming the function after the block type.
+func TransformBlockSpec(typeName string, required bool, opts ...Option) hcldec.Spec {
+	cfg := newConfig(opts)
+	ty := transformType(nil)
+
+	// The query is compiled while validating, where diagnostics can point
+	// at it, and the transform only wraps the result
+	validate := func(block cty.Value) hcl.Diagnostics {
+		queryVal := block.GetAttr("query")
+		if queryVal.IsNull() || !queryVal.IsKnown() {
+			// A missing query is reported with the block's content
+			return nil
+		}
+		query := queryVal.EncapsulatedValue().(*querySource)
+		var params []string
+		if paramsVal := block.GetAttr("params"); !paramsVal.IsNull() {
+			params = *paramsVal.EncapsulatedValue().(*[]string)
+		}
+
+		var diags hcl.Diagnostics
+		query.compiled, diags = compileTransform(typeName, query.query, params, query.rng, cfg)
+		return diags
+	}
+	wrap := function.New(&function.Spec{
+		Params: []function.Parameter{{Name: "block", Type: cty.DynamicPseudoType}},
+		Type:   function.StaticReturnType(ty),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			queryVal := args[0].GetAttr("query")
+			if queryVal.IsNull() || !queryVal.IsKnown() {
+				return cty.NullVal(ty), nil
+			}
+			return cty.CapsuleVal(ty, queryVal.EncapsulatedValue().(*querySource).compiled), nil
+		},
+	})
+
+	return &hcldec.BlockSpec{
+		TypeName: typeName,
+		Required: required,
+		Nested: &hcldec.TransformFuncSpec{
+			Wrapped: &hcldec.ValidateSpec{
+				Wrapped: hcldec.ObjectSpec{
+					"query":  &hcldec.AttrSpec{Name: "query", Type: querySourceType, Required: true},
+					"params": &hcldec.AttrSpec{Name: "params", Type: paramsType},
+				},
+				Func: validate,
+			},
+			Func: wrap,
+		},
+	}
+}
+
+// TransformFunction returns the compiled function held by a value decoded
+// with TransformSpec or TransformBlockSpec, or nil if the value is null
+func TransformFunction(val cty.Value) (*JqFunction, error) {
+	ty := val.Type()
+	if !ty.IsCapsuleType() || ty.EncapsulatedType() != jqFunctionType {
+		return nil, fmt.Errorf("value of type %s is not a jq transform", ty.FriendlyName())
+	}
+	if val.IsNull() {
+		return nil, nil
+	}
+	if !val.IsKnown() {
+		return nil, fmt.Errorf("jq transform is not known")
+	}
+	return val.EncapsulatedValue().(*JqFunction), nil
+}
+
+// transformType creates a capsule type for compiled functions, decoded from
+// expressions by decode when it is not nil
+func transformType(decode customdecode.CustomExpressionDecoderFunc) cty.Type {
+	return cty.CapsuleWithOps("jq transform", jqFunctionType, &cty.CapsuleOps{
+		ExtensionData: customDecoder(decode),
+	})
+}
+
+// compileTransform compiles a transform with the settings from cfg
+func compileTransform(name, query string, params []string, rng hcl.Range, cfg *config) (*JqFunction, hcl.Diagnostics) {
+	return compileJqFunction(cfg.functionDef(name, query, params, rng))
+}
+
+// decodeTransformExpr decodes the query and params of a transform attribute
+func decodeTransformExpr(expr hcl.Expression, ctx *hcl.EvalContext) (string, []string, hcl.Diagnostics) {
+	objExpr, ok := expr.(*hclsyntax.ObjectConsExpr)
+	if !ok {
+		query, diags := decodeQueryExpr(expr, ctx)
+		return query, nil, diags
+	}
+
+	var diags hcl.Diagnostics
+	var queryExpr, paramsExpr hcl.Expression
+	for _, item := range objExpr.Items {
+		key := hcl.ExprAsKeyword(item.KeyExpr)
+		switch key {
+		case "query":
+			queryExpr = item.ValueExpr
+		case "params":
+			paramsExpr = item.ValueExpr
+		default:
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid jq transform",
+				Detail:   "A jq transform object may only have the attributes query and params",
+				Subject:  item.KeyExpr.Range().Ptr(),
+			})
+		}
+	}
+	if queryExpr == nil {
+		diags = diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Missing query",
+			Detail:   "A jq transform object must specify a 'query' attribute",
+			Subject:  expr.Range().Ptr(),
+		})
+	}
+	if diags.HasErrors() {
+		return "", nil, diags
+	}
+
+	query, queryDiags := decodeQueryExpr(queryExpr, ctx)
+	diags = diags.Extend(queryDiags)
+
+	var params []string
+	if paramsExpr != nil {
+		var paramDiags hcl.Diagnostics
+		params, paramDiags = parseParamsList(paramsExpr)
+		diags = diags.Extend(paramDiags)
+	}
+	return query, params, diags
+}
+
+// decodeQueryExpr evaluates a query expression, which must produce a string
+// that parses as a jq query
+func decodeQueryExpr(expr hcl.Expression, ctx *hcl.EvalContext) (string, hcl.Diagnostics) {
+	val, diags := expr.Value(ctx)
+	if diags.HasErrors() {
+		return "", diags
+	}
+	if val.Type() != cty.String || val.IsNull() || !val.IsKnown() || val.AsString() == "" {
+		return "", diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid query type",
+			Detail:   "Query must be a non-empty string",
+			Subject:  expr.Range().Ptr(),
+		})
+	}
+
+	query := val.AsString()
+	if _, err := gojq.Parse(query); err != nil {
+		return "", diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid jq query",
+			Detail:   fmt.Sprintf("Failed to parse jq query: %s", err),
+			Subject:  expr.Range().Ptr(),
+		})
+	}
+	return query, diags
+}
+
+// querySource is a query string together with where it was defined
+type querySource struct {
+	query string
+	rng   hcl.Range
+
+	// compiled is the function compiled from the query, once validated
+	compiled *JqFunction
+}
+
+var (
+	// querySourceType decodes the query attribute of a transform block,
+	// keeping its source range for diagnostics
+	querySourceType cty.Type
+
+	// paramsType decodes the params attribute of a transform block as bare
+	// identifiers
+	paramsType cty.Type
+)
+
+func init() {
+	querySourceType = cty.CapsuleWithOps("jq query", reflect.TypeOf(querySource{}), &cty.CapsuleOps{
+		ExtensionData: customDecoder(func(expr hcl.Expression, ctx *hcl.EvalContext) (cty.Value, hcl.Diagnostics) {
+			query, diags := decodeQueryExpr(expr, ctx)
+			if diags.HasErrors() {
+				return cty.NilVal, diags
+			}
+			return cty.CapsuleVal(querySourceType, &querySource{query: query, rng: expr.Range()}), diags
+		}),
+	})
+
+	paramsType = cty.CapsuleWithOps("jq params", reflect.TypeOf([]string(nil)), &cty.CapsuleOps{
+		ExtensionData: customDecoder(func(expr hcl.Expression, ctx *hcl.EvalContext) (cty.Value, hcl.Diagnostics) {
+			params, diags := parseParamsList(expr)
+			if diags.HasErrors() {
+				return cty.NilVal, diags
+			}
+			return cty.CapsuleVal(paramsType, &params), diags
+		}),
+	})
+}
+
+// customDecoder returns a capsule ExtensionData function providing decode
+// as the custom expression decoder, if it is not nil
+func customDecoder(decode customdecode.CustomExpressionDecoderFunc) func(key interface{}) interface{} {
+	return func(key interface{}) interface{} {
+		if key == customdecode.CustomExpressionDecoder && decode != nil {
+			return decode
+		}
+		return nil
+	}
+}
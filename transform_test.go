@@ -0,0 +1,162 @@
+package jqfunc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestTransformSpec(t *testing.T) {
+	spec := hcldec.ObjectSpec{
+		"name":      &hcldec.AttrSpec{Name: "name", Type: cty.String},
+		"simple":    TransformSpec("simple", false),
+		"with_args": TransformSpec("with_args", false),
+		"typed":     TransformSpec("typed", false, WithReturnType(cty.List(cty.String))),
+		"block":     TransformBlockSpec("transform", false),
+	}
+
+	decode := func(t *testing.T, hclCode string, ctx *hcl.EvalContext) (cty.Value, hcl.Diagnostics) {
+		t.Helper()
+		return hcldec.Decode(parseTestBody(t, hclCode), spec, ctx)
+	}
+	transform := func(t *testing.T, val cty.Value, attr string) *JqFunction {
+		t.Helper()
+		fn, err := TransformFunction(val.GetAttr(attr))
+		require.NoError(t, err)
+		require.NotNil(t, fn, "Transform %s should be set", attr)
+		return fn
+	}
+
+	t.Run("implied type", func(t *testing.T) {
+		ty := hcldec.ImpliedType(spec)
+		require.True(t, ty.IsObjectType())
+		for _, attr := range []string{"simple", "with_args", "typed", "block"} {
+			attrType := ty.AttributeType(attr)
+			assert.True(t, attrType.IsCapsuleType(), "%s should be a capsule type", attr)
+			assert.Equal(t, "jq transform", attrType.FriendlyName())
+		}
+	})
+
+	t.Run("attribute forms", func(t *testing.T) {
+		val, diags := decode(t, `
+name = "example"
+simple = ".items[].name"
+with_args = { query = ".items[] | select(.kind == $kind) | .name", params = [kind] }
+typed = "[.items[].name]"
+`, nil)
+		require.False(t, diags.HasErrors(), "Decoding should succeed: %s", diags)
+		assert.Equal(t, "example", val.GetAttr("name").AsString())
+
+		input := cty.StringVal(`{"items": [{"name": "a", "kind": "x"}, {"name": "b", "kind": "y"}]}`)
+
+		simple := transform(t, val, "simple")
+		assert.Equal(t, "simple", simple.Name)
+		assert.Equal(t, 3, simple.Range.Start.Line)
+		result, err := simple.Function().Call([]cty.Value{input})
+		require.NoError(t, err)
+		assert.Equal(t, `["a","b"]`, result.AsString())
+
+		withArgs := transform(t, val, "with_args")
+		assert.Equal(t, []string{"kind"}, withArgs.Params)
+		result, err = withArgs.Function().Call([]cty.Value{input, cty.StringVal("y")})
+		require.NoError(t, err)
+		assert.Equal(t, "b", result.AsString())
+
+		typed := transform(t, val, "typed")
+		result, err = typed.Function().Call([]cty.Value{input})
+		require.NoError(t, err)
+		assert.True(t, result.RawEquals(cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")})), "Got %#v", result)
+
+		// Absent transforms are null
+		fn, err := TransformFunction(val.GetAttr("block"))
+		require.NoError(t, err)
+		assert.Nil(t, fn)
+	})
+
+	t.Run("block form", func(t *testing.T) {
+		val, diags := decode(t, `
+transform {
+    params = [factor]
+    query = "map(. * $factor)"
+}
+`, nil)
+		require.False(t, diags.HasErrors(), "Decoding should succeed: %s", diags)
+
+		fn := transform(t, val, "block")
+		assert.Equal(t, "transform", fn.Name)
+		results, err := fn.Run(context.Background(), []interface{}{1, 2}, 3)
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{[]interface{}{3, 6}}, results)
+	})
+
+	t.Run("eval context", func(t *testing.T) {
+		ctx := &hcl.EvalContext{Variables: map[string]cty.Value{"field": cty.StringVal("id")}}
+		val, diags := decode(t, `
+simple = ".${field}"
+transform {
+    query = ".${field} + 1"
+}
+`, ctx)
+		require.False(t, diags.HasErrors(), "Decoding should succeed: %s", diags)
+
+		results, err := transform(t, val, "simple").Run(context.Background(), map[string]interface{}{"id": 7})
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{7}, results)
+
+		results, err = transform(t, val, "block").Run(context.Background(), map[string]interface{}{"id": 7})
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{8}, results)
+	})
+
+	t.Run("diagnostics", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			hcl     string
+			summary string
+			line    int
+		}{
+			{"invalid query", "\nsimple = \".[\"\n", "Invalid jq query", 2},
+			{"not a string", "\nsimple = 42\n", "Invalid query type", 2},
+			{"unknown attribute", "\nwith_args = { query = \".\", args = [a] }\n", "Invalid jq transform", 2},
+			{"missing query", "\nwith_args = { params = [a] }\n", "Missing query", 2},
+			{"invalid params", "\nwith_args = { query = \".\", params = [\"a\"] }\n", "Invalid parameter", 2},
+			{"undefined variable", "\nsimple = \"$nope\"\n", "Failed to compile jq query", 2},
+			{"invalid block query", "\ntransform {\n  query = \".[\"\n}\n", "Invalid jq query", 3},
+			{"invalid block params", "\ntransform {\n  query = \".\"\n  params = [a.b]\n}\n", "Invalid parameter", 4},
+			{"missing block query", "\ntransform {\n}\n", "Missing required argument", 2},
+			{"undefined block variable", "\ntransform {\n  query = \"$nope\"\n}\n", "Failed to compile jq query", 3},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, diags := decode(t, tt.hcl, nil)
+				require.True(t, diags.HasErrors(), "Decoding should fail")
+				assert.Equal(t, tt.summary, diags[0].Summary)
+				require.NotNil(t, diags[0].Subject)
+				assert.Equal(t, tt.line, diags[0].Subject.Start.Line)
+			})
+		}
+	})
+
+	t.Run("required", func(t *testing.T) {
+		body := parseTestBody(t, "\n")
+		_, diags := hcldec.Decode(body, hcldec.ObjectSpec{"t": TransformSpec("t", true)}, nil)
+		require.True(t, diags.HasErrors())
+		assert.Equal(t, "Missing required argument", diags[0].Summary)
+
+		_, diags = hcldec.Decode(body, hcldec.ObjectSpec{"t": TransformBlockSpec("t", true)}, nil)
+		require.True(t, diags.HasErrors())
+		assert.Equal(t, "Missing t block", diags[0].Summary)
+	})
+
+	t.Run("not a transform", func(t *testing.T) {
+		_, err := TransformFunction(cty.StringVal("."))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not a jq transform")
+	})
+}
@@ -0,0 +1,311 @@
+package jqfunc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// YAML input is converted to the values gojq works with from yaml.v3's node
+// tree, so that types are resolved the same way for every document:
+//
+//   - null, booleans, strings and numbers map to their jq counterparts;
+//     integers too large for 64 bits are kept exact, like JSON integers
+//   - timestamps become strings, in RFC 3339 form or as a date for dates
+//     without a time
+//   - !!binary values stay base64 strings, and scalars with custom tags are
+//     read as strings
+//   - mapping keys that are not strings use their JSON text, so 1 becomes
+//     "1" and null becomes "null"; keys that are mappings or sequences are
+//     an error, and so are keys that occur twice
+//   - merge keys (<<) are applied, and aliases are expanded
+//
+// Errors give the document, counting from 1, and the line.
+
+// maxYAMLAliasNodes limits the nodes reached through aliases in one
+// document, which could otherwise grow exponentially
+const maxYAMLAliasNodes = 1000000
+
+// decodeYAML parses a stream of YAML documents, returning one value per
+// document
+func decodeYAML(data []byte) ([]interface{}, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+
+	var docs []interface{}
+	for {
+		var node yaml.Node
+		if err := dec.Decode(&node); err != nil {
+			if errors.Is(err, io.EOF) {
+				return docs, nil
+			}
+			return nil, fmt.Errorf("document %d: %s", len(docs)+1, strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+
+		d := &yamlDecoder{doc: len(docs) + 1}
+		v, err := d.value(&node, 0)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, v)
+	}
+}
+
+// yamlDecoder converts the node tree of a single document
+type yamlDecoder struct {
+	doc        int
+	aliasDepth int // aliases being expanded
+	aliasNodes int // nodes reached through aliases
+}
+
+// errorf reports an error at a node
+func (d *yamlDecoder) errorf(node *yaml.Node, format string, args ...interface{}) error {
+	return fmt.Errorf("document %d: line %d: %s", d.doc, node.Line, fmt.Sprintf(format, args...))
+}
+
+func (d *yamlDecoder) value(node *yaml.Node, depth int) (interface{}, error) {
+	if depth > maxJSONDepth {
+		return nil, d.errorf(node, "exceeded max depth of %d", maxJSONDepth)
+	}
+	if d.aliasDepth > 0 {
+		if d.aliasNodes++; d.aliasNodes > maxYAMLAliasNodes {
+			return nil, d.errorf(node, "aliases expand to more than %d values", maxYAMLAliasNodes)
+		}
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return d.value(node.Content[0], depth)
+	case yaml.AliasNode:
+		d.aliasDepth++
+		defer func() { d.aliasDepth-- }()
+		return d.value(node.Alias, depth)
+	case yaml.SequenceNode:
+		result := make([]interface{}, len(node.Content))
+		for i, elem := range node.Content {
+			v, err := d.value(elem, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = v
+		}
+		return result, nil
+	case yaml.MappingNode:
+		return d.mapping(node, depth)
+	default:
+		return d.scalar(node)
+	}
+}
+
+// mapping converts a mapping. Merged keys never replace keys of the mapping
+// itself, and earlier merges take precedence over later ones.
+func (d *yamlDecoder) mapping(node *yaml.Node, depth int) (interface{}, error) {
+	result := make(map[string]interface{}, len(node.Content)/2)
+	var merges []*yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		if keyNode.Kind == yaml.ScalarNode && keyNode.ShortTag() == "!!merge" {
+			merges = append(merges, valueNode)
+			continue
+		}
+
+		key, err := d.key(keyNode)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := result[key]; ok {
+			return nil, d.errorf(keyNode, "duplicate key %q", key)
+		}
+		if result[key], err = d.value(valueNode, depth+1); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, mergeNode := range merges {
+		sources := []*yaml.Node{mergeNode}
+		if mergeNode.Kind == yaml.SequenceNode {
+			sources = mergeNode.Content
+		}
+		for _, source := range sources {
+			v, err := d.value(source, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, d.errorf(source, "merge value must be a mapping or a sequence of mappings")
+			}
+			for key, value := range obj {
+				if _, ok := result[key]; !ok {
+					result[key] = value
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// key converts a mapping key to an object key
+func (d *yamlDecoder) key(node *yaml.Node) (string, error) {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind != yaml.ScalarNode {
+		return "", d.errorf(node, "mapping keys must be scalars")
+	}
+
+	v, err := d.scalar(node)
+	if err != nil {
+		return "", err
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	text, err := encodeJSON(v)
+	if err != nil {
+		return "", d.errorf(node, "invalid key: %v", err)
+	}
+	return string(text), nil
+}
+
+// scalar converts a scalar according to its resolved tag
+func (d *yamlDecoder) scalar(node *yaml.Node) (interface{}, error) {
+	switch node.ShortTag() {
+	case "!!null":
+		return nil, nil
+	case "!!bool", "!!int", "!!float":
+		// Integers that overflow 64 bits are resolved as floats; keep them
+		// exact instead
+		if digits := strings.ReplaceAll(node.Value, "_", ""); isDecimalInteger(digits) {
+			if i, ok := new(big.Int).SetString(strings.TrimPrefix(digits, "+"), 10); ok && !i.IsInt64() {
+				return i, nil
+			}
+		}
+
+		var v interface{}
+		if err := node.Decode(&v); err != nil {
+			return nil, d.errorf(node, "%s", strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+		return normalizeGoValue(v)
+	case "!!timestamp":
+		var t time.Time
+		if err := node.Decode(&t); err != nil {
+			return nil, d.errorf(node, "%s", strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+		if len(strings.TrimSpace(node.Value)) == len("2006-01-02") {
+			return t.Format("2006-01-02"), nil
+		}
+		return t.Format(time.RFC3339Nano), nil
+	case "!!binary":
+		return strings.Join(strings.Fields(node.Value), ""), nil
+	default:
+		return node.Value, nil
+	}
+}
+
+// isDecimalInteger reports whether s is an optionally signed decimal integer
+func isDecimalInteger(s string) bool {
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		s = s[1:]
+	}
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeYAML encodes a gojq result as a YAML document with sorted keys.
+// Numbers are written as in JSON output.
+func encodeYAML(v interface{}) ([]byte, error) {
+	node, err := yamlNode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// yamlNode builds the node tree for a gojq value. Scalars other than strings
+// are left untagged, so they are written plain; strings are tagged so that
+// the encoder quotes them when they would otherwise read as another type.
+func yamlNode(v interface{}) (*yaml.Node, error) {
+	switch v := v.(type) {
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: "null"}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: strconv.FormatBool(v)}, nil
+	case int:
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: strconv.Itoa(v)}, nil
+	case *big.Int:
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: v.String()}, nil
+	case float64:
+		if math.IsNaN(v) {
+			return &yaml.Node{Kind: yaml.ScalarNode, Value: "null"}, nil
+		}
+		v = math.Max(math.Min(v, math.MaxFloat64), -math.MaxFloat64)
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: strconv.FormatFloat(v, 'f', -1, 64)}, nil
+	case string:
+		node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}
+		if strings.Contains(v, "\n") {
+			node.Style = yaml.LiteralStyle
+		}
+		return node, nil
+	case []interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Content: make([]*yaml.Node, len(v))}
+		for i, elem := range v {
+			var err error
+			if node.Content[i], err = yamlNode(elem); err != nil {
+				return nil, err
+			}
+		}
+		return node, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		node := &yaml.Node{Kind: yaml.MappingNode, Content: make([]*yaml.Node, 0, 2*len(keys))}
+		for _, key := range keys {
+			valueNode, err := yamlNode(v[key])
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, valueNode)
+		}
+		return node, nil
+	default:
+		// Values from custom gojq functions may be of other types
+		node := &yaml.Node{}
+		if err := node.Encode(v); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+}
@@ -0,0 +1,226 @@
+package jqfunc
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestDecodeYAML(t *testing.T) {
+	tests := []struct {
+		name     string
+		yaml     string
+		expected []interface{}
+	}{
+		{"scalars", "[~, null, true, 42, -7, 1.5, 0x1F, 1_000, plain, '42', !!str 7]",
+			[]interface{}{[]interface{}{nil, nil, true, 42, -7, 1.5, 31, 1000, "plain", "42", "7"}}},
+		{"big integers stay exact", "[123456789012345678901234567890, 18446744073709551615]",
+			[]interface{}{[]interface{}{mustBigInt("123456789012345678901234567890"), mustBigInt("18446744073709551615")}}},
+		{"special floats", "[.inf, -.inf]",
+			[]interface{}{[]interface{}{math.Inf(1), math.Inf(-1)}}},
+		{"timestamps", "[2001-12-14t21:59:43.10-05:00, 2002-12-14, '2002-12-14']",
+			[]interface{}{[]interface{}{"2001-12-14T21:59:43.1-05:00", "2002-12-14", "2002-12-14"}}},
+		{"binary and custom tags", "[!!binary 'aGVs\n bG8=', !Ref name]",
+			[]interface{}{[]interface{}{"aGVsbG8=", "name"}}},
+		{"non-string keys", "{1: a, true: b, null: c, 1.5: d}",
+			[]interface{}{map[string]interface{}{"1": "a", "true": "b", "null": "c", "1.5": "d"}}},
+		{"aliases", "base: &b {x: 1}\ncopy: *b\n",
+			[]interface{}{map[string]interface{}{"base": map[string]interface{}{"x": 1}, "copy": map[string]interface{}{"x": 1}}}},
+		{"merge keys", "a: &a {x: 1, y: 1}\nb: &b {y: 2, z: 2}\nc:\n  <<: [*a, *b]\n  x: 3\n",
+			[]interface{}{map[string]interface{}{
+				"a": map[string]interface{}{"x": 1, "y": 1},
+				"b": map[string]interface{}{"y": 2, "z": 2},
+				"c": map[string]interface{}{"x": 3, "y": 1, "z": 2},
+			}}},
+		{"multiple documents", "a: 1\n---\n- 2\n---\n", []interface{}{map[string]interface{}{"a": 1}, []interface{}{2}, nil}},
+		{"empty stream", "", nil},
+		{"comments only", "# nothing here\n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs, err := decodeYAML([]byte(tt.yaml))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, docs)
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		errorTests := []struct {
+			name     string
+			yaml     string
+			expected string
+		}{
+			{"syntax", "a: 1\n---\nb: x: y\n", "document 2: line 3: mapping values are not allowed in this context"},
+			{"duplicate key", "a: 1\nb:\n  x: 1\n  x: 2\n", "document 1: line 4: duplicate key \"x\""},
+			{"keys that collide", "{1: a, '1': b}", "document 1: line 1: duplicate key \"1\""},
+			{"mapping key", "---\n---\n? [a]\n: b\n", "document 2: line 3: mapping keys must be scalars"},
+			{"merge of a scalar", "a:\n  <<: 1\n", "document 1: line 2: merge value must be a mapping or a sequence of mappings"},
+			{"bad timestamp", "!!timestamp nope", "document 1: line 1:"},
+		}
+		for _, tt := range errorTests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := decodeYAML([]byte(tt.yaml))
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expected)
+			})
+		}
+	})
+
+	t.Run("alias expansion is limited", func(t *testing.T) {
+		// Each level doubles the size of the expansion
+		var doc strings.Builder
+		doc.WriteString("a0: &a0 [x, x]\n")
+		for i := 1; i <= 24; i++ {
+			doc.WriteString(strings.NewReplacer("N", strconv.Itoa(i), "P", strconv.Itoa(i-1)).Replace("aN: &aN [*aP, *aP]\n"))
+		}
+		_, err := decodeYAML([]byte(doc.String()))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "aliases expand to more than")
+	})
+}
+
+func TestEncodeYAML(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"scalar", 42, "42\n"},
+		{"null", nil, "null\n"},
+		{"exact integer", mustBigInt("123456789012345678901234567890"), "123456789012345678901234567890\n"},
+		{"floats like JSON", []interface{}{1.5, 1e21, math.NaN()}, "- 1.5\n- 1000000000000000000000\n- null\n"},
+		{"strings that need quotes", []interface{}{"true", "42", "null", "a: b", ""}, "- \"true\"\n- \"42\"\n- \"null\"\n- 'a: b'\n- \"\"\n"},
+		{"multiline string", map[string]interface{}{"text": "line 1\nline 2\n"}, "text: |\n  line 1\n  line 2\n"},
+		{"sorted keys", map[string]interface{}{"b": []interface{}{1, 2}, "a": map[string]interface{}{"c": true}},
+			"a:\n  c: true\nb:\n  - 1\n  - 2\n"},
+		{"empty collections", map[string]interface{}{"list": []interface{}{}, "map": map[string]interface{}{}}, "list: []\nmap: {}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := encodeYAML(tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(encoded))
+		})
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		value := map[string]interface{}{
+			"strings": []interface{}{"true", "1.5", "~", "- x", "#", "multi\nline", "  padded  "},
+			"numbers": []interface{}{0, -1, 2.25, mustBigInt("-123456789012345678901234567890")},
+			"nested":  map[string]interface{}{"": nil, "key: with colon": false},
+		}
+		encoded, err := encodeYAML(value)
+		require.NoError(t, err)
+		docs, err := decodeYAML(encoded)
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{value}, docs)
+	})
+}
+
+func TestYAMLFormats(t *testing.T) {
+	body := parseTestBody(t, `
+jq "names" {
+    input_format = "yaml"
+    query        = ".items[].name"
+}
+
+jq "to_yaml" {
+    output_format = "yaml"
+    query         = "{name: .name, tags: .tags}"
+}
+
+jq "convert" {
+    input_format  = "yaml"
+    output_format = "yaml"
+    query         = ".spec"
+}
+
+jq "kinds" {
+    input_format = "yaml"
+    results      = "array"
+    query        = ".kind"
+}
+`)
+	functions, _, diags := DecodeJqFunctions(body, "jq")
+	require.False(t, diags.HasErrors(), "Decoding should succeed: %s", diags)
+
+	t.Run("yaml input", func(t *testing.T) {
+		result, err := functions["names"].Call([]cty.Value{cty.StringVal("items:\n  - name: a\n  - name: b\n")})
+		require.NoError(t, err)
+		assert.Equal(t, cty.StringVal(`["a","b"]`), result)
+	})
+
+	t.Run("cty input with yaml output", func(t *testing.T) {
+		result, err := functions["to_yaml"].Call([]cty.Value{cty.ObjectVal(map[string]cty.Value{
+			"name": cty.StringVal("web"),
+			"tags": cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}),
+		})})
+		require.NoError(t, err)
+		assert.Equal(t, cty.StringVal("name: web\ntags:\n  - a\n  - b\n"), result)
+	})
+
+	t.Run("yaml to yaml", func(t *testing.T) {
+		result, err := functions["convert"].Call([]cty.Value{cty.StringVal("spec:\n  replicas: 3\n  image: 'nginx:1.27'\n")})
+		require.NoError(t, err)
+		assert.Equal(t, cty.StringVal("image: nginx:1.27\nreplicas: 3\n"), result)
+	})
+
+	t.Run("each document is an input", func(t *testing.T) {
+		result, err := functions["kinds"].Call([]cty.Value{cty.StringVal("kind: Service\n---\nkind: Deployment\n")})
+		require.NoError(t, err)
+		assert.Equal(t, cty.StringVal(`["Service","Deployment"]`), result)
+
+		result, err = functions["kinds"].Call([]cty.Value{cty.StringVal("")})
+		require.NoError(t, err)
+		assert.Equal(t, cty.StringVal(`[]`), result)
+	})
+
+	t.Run("invalid input names the document", func(t *testing.T) {
+		_, err := functions["kinds"].Call([]cty.Value{cty.StringVal("kind: a\n---\nkind: b: c\n")})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid YAML input: document 2: line 3:")
+	})
+
+	t.Run("invalid attributes", func(t *testing.T) {
+		body := parseTestBody(t, `
+jq "bad_input" {
+    input_format = "xml"
+    query        = "."
+}
+
+jq "bad_output" {
+    output_format = "csv"
+    query         = "."
+}
+
+jq "not_a_string" {
+    output_format = "yaml"
+    return_type   = list(string)
+    query         = "."
+}
+`)
+		_, _, diags := DecodeJqFunctions(body, "jq")
+		require.Len(t, diags, 3)
+		assert.Equal(t, `input_format must be one of "json", "yaml", "ndjson", "csv", "tsv", "toml", "hcl", "jsonc" or "json5"`, diags[0].Detail)
+		assert.Equal(t, `output_format must be one of "json", "yaml" or "ndjson"`, diags[1].Detail)
+		assert.Equal(t, "output_format produces strings, so return_type must be string, not list of string", diags[2].Detail)
+	})
+
+	t.Run("options", func(t *testing.T) {
+		fn, err := New("convert", ".", nil, WithInputFormat(FormatYAML), WithOutputFormat(FormatJSON))
+		require.NoError(t, err)
+		result, err := fn.Call([]cty.Value{cty.StringVal("a: [1, yes]")})
+		require.NoError(t, err)
+		assert.Equal(t, cty.StringVal(`{"a":[1,"yes"]}`), result)
+
+		_, err = New("bad", ".", nil, WithInputFormat("xml"))
+		assert.EqualError(t, err, `jq function bad: invalid input format "xml"`)
+	})
+}